@@ -4,6 +4,7 @@ import (
 	"cloud-node-lifecycle-controller/pkg/client"
 	"cloud-node-lifecycle-controller/pkg/config"
 	"cloud-node-lifecycle-controller/pkg/controller"
+	"cloud-node-lifecycle-controller/pkg/metrics"
 	"cloud-node-lifecycle-controller/pkg/option"
 	"cloud-node-lifecycle-controller/pkg/provider"
 	"cloud-node-lifecycle-controller/pkg/server"
@@ -12,6 +13,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -26,8 +28,12 @@ import (
 // NAMESPACE cloud node lifecycle controller lease namespace
 const (
 	NAMESPACE = "kube-system"
+	LeaseName = "cloud-node-lifecycle-controller"
 )
 
+// providerInitRetry how often the initialization of the cloud providers is retried
+const providerInitRetry = 30 * time.Second
+
 var processIndentify string
 
 func init() {
@@ -46,39 +52,96 @@ func main() {
 		Use:   "cloud-node-lifecycle-controller",
 		Short: "cloud-node-lifecycle-controller",
 		Run: func(cmd *cobra.Command, args []string) {
-			if o.CloudProvider == "" {
-				klog.Fatalf("cloud provider can't be empty")
-				return
-			}
-			if _, ok := provider.DefaultInitFuncConstructors[o.CloudProvider]; !ok {
-				klog.Fatalf("cloud provider %s not support", o.CloudProvider)
-				return
+			if o.ConfigFile != "" {
+				base := o
+				file, err := option.LoadConfigFile(o.ConfigFile)
+				if err != nil {
+					klog.Fatalf("load config file error: %v", err)
+					return
+				}
+				if err := file.ApplyTo(&o, cmd.Flags().Changed); err != nil {
+					klog.Fatalf("apply config file %s error: %v", o.ConfigFile, err)
+					return
+				}
+				go config.WatchConfigFile(context.Background(), o.ConfigFile, base, cmd.Flags().Changed)
 			}
-			if o.Region == "" {
-				klog.Fatalf("region can't be empty")
+			o.Complete()
+			if err := o.Validate(); err != nil {
+				klog.Fatalf("invalid options: %v", err)
 				return
 			}
-
-			api, err := provider.DefaultInitFuncConstructors[o.CloudProvider]()
-			if err != nil {
-				return
+			for _, name := range o.CloudProviders() {
+				if _, ok := provider.DefaultInitFuncConstructors[name]; !ok {
+					klog.Fatalf("cloud provider %s not support", name)
+					return
+				}
 			}
 
-			client.CloudProviderAPI = api
 			initClusterConfig(o.InCluster, o.KubeConfig)
-			go startLeaderElection(stopCh)
+			// the probes are served while the providers check their credentials
+			go server.NewAPIServer(o.Port, o.AdminTokenFile)
+			go func() {
+				client.CloudProviderAPI = initCloudProviders(o.CloudProviders())
+				startLeaderElection(stopCh)
+			}()
 		},
 	}
 
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	cmd.PersistentFlags().StringVar(&o.ConfigFile, "config", "", "path of the "+option.ConfigAPIVersion+" config file, flags set on the command line override it")
 	cmd.PersistentFlags().StringVar(&o.KubeConfig, "kube-config", "", "Absolute path to the kubeconfig file. Required only when running out of cluster.")
 	cmd.PersistentFlags().BoolVar(&o.InCluster, "in-cluster", true, "If not in cluster,need to specify kubeconfig path")
-	cmd.PersistentFlags().StringVar(&o.CloudProvider, "cloud-provider", "", "cloud provider, support aws azure tencent")
-	cmd.PersistentFlags().StringVar(&o.SubscriptionID, "subscription-id", "", "subscription id for azure cloud provider")
-	cmd.PersistentFlags().StringVar(&o.Region, "region", "", "instance region")
-	cmd.PersistentFlags().StringVar(&o.AccessKeyID, "access-key-id", "", "access key id")
-	cmd.PersistentFlags().StringVar(&o.SecretKeyID, "secret-key-id", "", "secret")
+	cmd.PersistentFlags().StringVar(&o.CloudProvider, "cloud-provider", "", "comma separated cloud providers, support aws azure tencent, nodes are routed by providerID scheme")
+	cmd.PersistentFlags().StringVar(&o.SubscriptionID, "subscription-id", "", "only act on the azure nodes of this subscription, default of --azure-subscription-id")
+	cmd.PersistentFlags().StringVar(&o.Region, "region", "", "instance region, default of --aws-region and --tencent-region")
+	cmd.PersistentFlags().StringVar(&o.AccessKeyID, "access-key-id", "", "access key id, default of --aws-access-key-id and --tencent-secret-id")
+	cmd.PersistentFlags().StringVar(&o.SecretKeyID, "secret-key-id", "", "secret, default of --aws-secret-key-id and --tencent-secret-key")
+	cmd.PersistentFlags().StringVar(&o.AWS.Region, "aws-region", "", "region for aws cloud provider")
+	cmd.PersistentFlags().StringSliceVar(&o.AWS.AllowedRegions, "aws-allowed-regions", nil, "regions of the aws nodes the controller acts on, the region is derived from the zone of the providerID, defaults to --aws-region")
+	cmd.PersistentFlags().StringVar(&o.AWS.AccessKeyID, "aws-access-key-id", "", "access key id for aws cloud provider")
+	cmd.PersistentFlags().StringVar(&o.AWS.SecretKeyID, "aws-secret-key-id", "", "secret access key for aws cloud provider, without static keys the default credential chain is used (env, IRSA, shared profile, instance profile)")
+	cmd.PersistentFlags().StringVar(&o.AWS.RoleARN, "aws-role-arn", "", "role assumed through STS by the aws cloud provider")
+	cmd.PersistentFlags().StringVar(&o.AWS.ExternalID, "aws-external-id", "", "external id passed when assuming --aws-role-arn")
+	cmd.PersistentFlags().StringVar(&o.Tencent.Region, "tencent-region", "", "region for tencent cloud provider")
+	cmd.PersistentFlags().StringSliceVar(&o.Tencent.AllowedRegions, "tencent-allowed-regions", nil, "regions of the tencent nodes the controller acts on, the region is derived from the zone of the providerID, defaults to --tencent-region")
+	cmd.PersistentFlags().StringVar(&o.Tencent.AccessKeyID, "tencent-secret-id", "", "secret id for tencent cloud provider")
+	cmd.PersistentFlags().StringVar(&o.Tencent.SecretKeyID, "tencent-secret-key", "", "secret key for tencent cloud provider, without static keys the SDK credential chain is used (env, profile, CVM role)")
+	cmd.PersistentFlags().StringVar(&o.Tencent.RoleARN, "tencent-role-arn", "", "CAM role assumed through STS by the tencent cloud provider")
+	cmd.PersistentFlags().StringVar(&o.Tencent.ExternalID, "tencent-external-id", "", "external id passed when assuming --tencent-role-arn")
+	cmd.PersistentFlags().StringVar(&o.Tencent.Endpoint, "tencent-endpoint", "cvm.tencentcloudapi.com", "CVM API endpoint, e.g. cvm.intl.tencentcloudapi.com or cvm.internal.tencentcloudapi.com from a VPC")
+	cmd.PersistentFlags().StringVar(&o.Azure.SubscriptionID, "azure-subscription-id", "", "only act on the azure nodes of this subscription, the subscription of each node is read from its providerID, defaults to --subscription-id")
+	cmd.PersistentFlags().StringSliceVar(&o.Azure.AllowedSubscriptions, "azure-allowed-subscriptions", nil, "subscriptions of the azure nodes the controller acts on, defaults to --azure-subscription-id, any subscription if both are empty")
+	cmd.PersistentFlags().StringVar(&o.Azure.Cloud, "azure-cloud", "public", "azure cloud of the nodes, support public china usgov")
+	cmd.PersistentFlags().StringVar(&o.Azure.ARMEndpoint, "azure-arm-endpoint", "", "custom azure resource manager endpoint, for Azure Stack or air-gapped clouds, overrides --azure-cloud")
+	cmd.PersistentFlags().StringVar(&o.Azure.ARMAudience, "azure-arm-audience", "", "token audience of --azure-arm-endpoint, defaults to the endpoint")
+	cmd.PersistentFlags().StringVar(&o.Azure.AuthorityHost, "azure-authority-host", "", "Microsoft Entra authority host of --azure-arm-endpoint")
+	cmd.PersistentFlags().StringVar(&o.Azure.CredentialType, "azure-credential-type", "default", "azure credential, support default managed-identity workload-identity client-secret client-certificate")
+	cmd.PersistentFlags().StringVar(&o.Azure.ClientID, "azure-client-id", "", "client id of the azure identity, the user-assigned managed identity or the app registration")
+	cmd.PersistentFlags().StringVar(&o.Azure.TenantID, "azure-tenant-id", "", "tenant id of the azure identity")
+	cmd.PersistentFlags().StringVar(&o.Azure.TokenFile, "azure-federated-token-file", "", "service account token file of the azure workload identity, defaults to AZURE_FEDERATED_TOKEN_FILE")
+	cmd.PersistentFlags().StringVar(&o.Azure.ClientSecretFile, "azure-client-secret-file", "", "file holding the client secret of the azure app registration")
+	cmd.PersistentFlags().StringVar(&o.Azure.CertificateFile, "azure-client-certificate-file", "", "PEM or PKCS#12 file holding the client certificate and private key of the azure app registration")
+	cmd.PersistentFlags().StringVar(&o.Azure.CertificatePasswordFile, "azure-client-certificate-password-file", "", "file holding the password of --azure-client-certificate-file")
 	cmd.PersistentFlags().StringVar(&o.Port, "port", "8080", "health check port")
+	cmd.PersistentFlags().StringVar(&o.AdminTokenFile, "admin-token-file", "", "file holding the bearer token of the /admin endpoints and of the circuit breaker reset, the admin endpoints are disabled without it")
+	cmd.PersistentFlags().IntVar(&o.Workers, "workers", 5, "number of nodes processed concurrently")
+	cmd.PersistentFlags().DurationVar(&o.ResyncPeriod, "resync-period", 30*time.Second, "how often every node is checked again")
+	cmd.PersistentFlags().BoolVar(&o.DryRun, "dry-run", false, "only report the nodes that would be deleted, as events and on /dry-run-report, without deleting them")
+	cmd.PersistentFlags().DurationVar(&o.DeletionGracePeriod, "deletion-grace-period", 0, "how long the instance must be consistently missing before the node is deleted, 0 deletes on first detection")
+	cmd.PersistentFlags().IntVar(&o.MaxDeletionsPerInterval, "max-deletions-per-interval", 10, "max nodes deleted within --deletion-interval before all deletions are halted, 0 disables the limit")
+	cmd.PersistentFlags().IntVar(&o.MaxDeletionPercentage, "max-deletion-percentage", 50, "max percentage of the cluster nodes deleted within --deletion-interval before all deletions are halted, 0 disables the limit")
+	cmd.PersistentFlags().IntVar(&o.PercentageMinNodes, "deletion-percentage-min-nodes", 10, "clusters with fewer nodes are only limited by --max-deletions-per-interval, so a small cluster can still delete its dead nodes, 0 always applies --max-deletion-percentage")
+	cmd.PersistentFlags().DurationVar(&o.DeletionInterval, "deletion-interval", 10*time.Minute, "time window of the deletion limits")
+	cmd.PersistentFlags().DurationVar(&o.CircuitBreakerResetTimeout, "circuit-breaker-reset-timeout", 0, "how long deletions stay halted once a limit is exceeded, 0 requires a manual reset through POST /circuit-breaker/reset with the admin token")
+	cmd.PersistentFlags().BoolVar(&o.DrainBeforeDelete, "drain-before-delete", false, "cordon the node and evict its pods through the Eviction API, respecting PodDisruptionBudgets, before deleting it")
+	cmd.PersistentFlags().DurationVar(&o.DrainTimeout, "drain-timeout", 5*time.Minute, "how long pods are evicted before the remaining ones are force deleted")
+	cmd.PersistentFlags().DurationVar(&o.BatchWindow, "batch-window", 100*time.Millisecond, "how long an instance lookup waits for the lookups of other nodes to join its batched cloud call, 0 disables the wait")
+	cmd.PersistentFlags().IntVar(&o.BatchSize, "batch-size", 100, "max nodes looked up in one batched cloud call")
+	cmd.PersistentFlags().DurationVar(&o.CloudCallTimeout, "cloud-call-timeout", 30*time.Second, "max duration of a cloud API call, the calls in flight are also cancelled when the leader lease is lost")
+	cmd.PersistentFlags().StringVar(&o.NodeSelector, "node-selector", "", "label selector of the nodes the controller watches, e.g. node.kubernetes.io/lifecycle=spot, all the nodes if empty")
+	cmd.PersistentFlags().StringVar(&o.ExcludeNodeSelector, "exclude-node-selector", "", "label selector of the watched nodes the controller ignores")
+	cmd.PersistentFlags().StringSliceVar(&o.ExcludeNodeNames, "exclude-node-names", nil, "name patterns of the watched nodes the controller ignores, e.g. gpu-*,infra-?, with the syntax of path.Match")
+	cmd.PersistentFlags().BoolVar(&o.IncludeControlPlane, "include-control-plane", false, "also act on the nodes labeled node-role.kubernetes.io/control-plane, they are ignored by default")
 
 	config.Options = &o
 
@@ -91,7 +154,6 @@ func main() {
 		close(stopCh)
 		cancel()
 	}()
-	go server.NewAPIServer(o.Port)
 	if err := cmd.Execute(); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%s", err.Error())
 		os.Exit(1)
@@ -101,11 +163,25 @@ func main() {
 
 }
 
+// initCloudProviders init the cloud providers, retrying until their credentials are accepted.
+// The readiness reports why they are not initialized meanwhile
+func initCloudProviders(names []string) provider.CloudAPI {
+	for {
+		api, err := provider.NewRouter(names)
+		controller.CloudProvidersInitialized(err)
+		if err == nil {
+			return api
+		}
+		klog.Errorf("init cloud provider error, retry in %s: %v", providerInitRetry, err)
+		time.Sleep(providerInitRetry)
+	}
+}
+
 func startLeaderElection(stop chan struct{}) {
 	clientset := client.Client
 	lock := &resourcelock.LeaseLock{
 		LeaseMeta: metav1.ObjectMeta{
-			Name:      "cloud-node-lifecycle-controller",
+			Name:      LeaseName,
 			Namespace: NAMESPACE,
 		},
 		Client: clientset.CoordinationV1(),
@@ -114,6 +190,7 @@ func startLeaderElection(stop chan struct{}) {
 		},
 	}
 	klog.Infof("start to acquire lease")
+	controller.SetIdentity(processIndentify)
 
 	leaderelection.RunOrDie(context.TODO(), leaderelection.LeaderElectionConfig{
 		Lock:            lock,
@@ -124,14 +201,24 @@ func startLeaderElection(stop chan struct{}) {
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(ctx context.Context) {
 				klog.Infof("current acquire leader success")
-				controller.CreateAndStartController(ctx, clientset, stop)
+				metrics.Leader.Set(1)
+				// copies of the node events are recorded on the lease so they survive the node deletion
+				eventRef := &corev1.ObjectReference{
+					APIVersion: "coordination.k8s.io/v1",
+					Kind:       "Lease",
+					Namespace:  NAMESPACE,
+					Name:       LeaseName,
+				}
+				controller.CreateAndStartController(ctx, clientset, eventRef, stop)
 			},
 			OnStoppedLeading: func() {
 				klog.Infof("current process:%s lost lease", processIndentify)
+				metrics.Leader.Set(0)
 				klog.Flush()
 				os.Exit(0)
 			},
 			OnNewLeader: func(identity string) {
+				controller.ObserveLeader(identity)
 				if identity == processIndentify {
 					klog.Infof("new leader is current process:%s", processIndentify)
 					return
@@ -3,6 +3,7 @@ package config
 import (
 	"cloud-node-lifecycle-controller/pkg/option"
 	"context"
+	"sync/atomic"
 )
 
 // CloudProvider cloud provider for server
@@ -10,3 +11,19 @@ var (
 	Options *option.Options
 	Context context.Context //context for server
 )
+
+// current options including the settings hot reloaded from the config file
+var current atomic.Pointer[option.Options]
+
+// Current return the latest options, read the settings that can be hot reloaded from it
+func Current() *option.Options {
+	if o := current.Load(); o != nil {
+		return o
+	}
+	return Options
+}
+
+// Update replace the latest options
+func Update(o *option.Options) {
+	current.Store(o)
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"bytes"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"context"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"os"
+	"reflect"
+	"time"
+)
+
+// reloadInterval how often the config file is checked for changes
+const reloadInterval = 10 * time.Second
+
+// WatchConfigFile reload the config file when its content changes and apply the settings that don't need a restart.
+// base are the options parsed from the command line, flagChanged reports the flags that override the file
+func WatchConfigFile(ctx context.Context, path string, base option.Options, flagChanged func(name string) bool) {
+	last, err := os.ReadFile(path)
+	if err != nil {
+		klog.Errorf("read config file %s error: %v", path, err)
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.Errorf("read config file %s error: %v", path, err)
+			return
+		}
+		if bytes.Equal(data, last) {
+			return
+		}
+		last = data
+		if err := reload(path, base, flagChanged); err != nil {
+			klog.Errorf("reload config file %s error, keep the current settings: %v", path, err)
+		}
+	}, reloadInterval)
+}
+
+func reload(path string, base option.Options, flagChanged func(name string) bool) error {
+	file, err := option.LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	next := base
+	if err := file.ApplyTo(&next, flagChanged); err != nil {
+		return err
+	}
+	next.Complete()
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	latest := *Current()
+	structural := next
+	structural.CopyReloadable(&latest)
+	if !reflect.DeepEqual(structural, latest) {
+		klog.Warningf("config file %s changed settings that need a restart, only reloadable settings are applied", path)
+	}
+	latest.CopyReloadable(&next)
+	Update(&latest)
+	klog.Infof("config file %s reloaded", path)
+	return nil
+}
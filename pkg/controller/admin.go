@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/provider"
+	"context"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotLeader the admin operation needs the controller, which only runs on the leader
+var ErrNotLeader = errors.New("this process is not the leader")
+
+// ErrNodeNotFound the node of the admin operation doesn't exist or is not watched
+var ErrNodeNotFound = errors.New("node not found")
+
+// ErrProviderNotEnabled the provider of the admin operation is not an enabled cloud provider
+var ErrProviderNotEnabled = errors.New("cloud provider not enabled")
+
+// running controller of the leader, nil on the other processes
+var running atomic.Pointer[Controller]
+
+// AdminState deletions paused by the operators and nodes on their way to deletion
+type AdminState struct {
+	Leader           LeaderStatus         `json:"leader"`
+	PausedDeletions  PauseState           `json:"pausedDeletions"`
+	PauseStateLoaded bool                 `json:"pauseStateLoaded"`
+	CircuitBreaker   CircuitBreakerStatus `json:"circuitBreaker"`
+	QueuedNodes      int                  `json:"queuedNodes"`
+	Nodes            []AdminNodeState     `json:"nodes"`
+}
+
+// AdminNodeState a node whose instance is missing or which is being drained
+type AdminNodeState struct {
+	Name              string     `json:"name"`
+	ProviderID        string     `json:"providerID"`
+	Ready             bool       `json:"ready"`
+	InstanceMissing   *time.Time `json:"instanceMissingSince,omitempty"`
+	GracePeriodEndsAt *time.Time `json:"gracePeriodEndsAt,omitempty"`
+	DrainStartedAt    *time.Time `json:"drainStartedAt,omitempty"`
+	Requeues          int        `json:"requeues"`
+}
+
+func runningController() (*Controller, error) {
+	if c := running.Load(); c != nil {
+		return c, nil
+	}
+	return nil, ErrNotLeader
+}
+
+// PauseDeletions pause the deletions of the provider, or of every provider if empty, until they are resumed.
+// The nodes are still checked, tainted and reported, only their deletion and drain are held
+func PauseDeletions(ctx context.Context, providerName, reason string) (PauseState, error) {
+	if _, err := runningController(); err != nil {
+		return PauseState{}, err
+	}
+	if providerName != "" && !providerEnabled(providerName) {
+		return PauseState{}, fmt.Errorf("%w: %s", ErrProviderNotEnabled, providerName)
+	}
+	pause := Pause{Reason: reason, Since: time.Now()}
+	state, err := pauses.update(ctx, func(state *PauseState) {
+		if providerName == "" {
+			state.All = &pause
+			return
+		}
+		if state.Providers == nil {
+			state.Providers = map[string]Pause{}
+		}
+		state.Providers[providerName] = pause
+	})
+	if err == nil {
+		klog.Warningf("admin: deletions paused for %s: %s", scopeName(providerName), reason)
+	}
+	return state, err
+}
+
+// ResumeDeletions resume the deletions of the provider, or lift every pause if empty
+func ResumeDeletions(ctx context.Context, providerName string) (PauseState, error) {
+	c, err := runningController()
+	if err != nil {
+		return PauseState{}, err
+	}
+	state, err := pauses.update(ctx, func(state *PauseState) {
+		if providerName == "" {
+			*state = PauseState{}
+			return
+		}
+		delete(state.Providers, providerName)
+	})
+	if err != nil {
+		return state, err
+	}
+	klog.Infof("admin: deletions resumed for %s", scopeName(providerName))
+	// the nodes held by the pause are checked again right away
+	go c.resync()
+	return state, nil
+}
+
+func scopeName(providerName string) string {
+	if providerName == "" {
+		return "all providers"
+	}
+	return "provider " + providerName
+}
+
+func providerEnabled(providerName string) bool {
+	for _, name := range config.Options.CloudProviders() {
+		if name == providerName {
+			return true
+		}
+	}
+	return false
+}
+
+// ReconcileNode process the node right away, or every watched node if the name is empty
+func ReconcileNode(nodeName string) error {
+	c, err := runningController()
+	if err != nil {
+		return err
+	}
+	if nodeName == "" {
+		klog.Infof("admin: reconcile all the nodes")
+		go c.resync()
+		return nil
+	}
+	if _, err := c.nodeLister.Get(nodeName); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ErrNodeNotFound
+		}
+		return err
+	}
+	klog.Infof("admin: reconcile node %s", nodeName)
+	c.queue.Add(nodeName)
+	return nil
+}
+
+// ClearNodeBackoff reset the rate limiting backoff of the node in the workqueue and process it right away
+func ClearNodeBackoff(nodeName string) error {
+	c, err := runningController()
+	if err != nil {
+		return err
+	}
+	if _, err := c.nodeLister.Get(nodeName); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ErrNodeNotFound
+		}
+		return err
+	}
+	klog.Infof("admin: clear the backoff of node %s after %d requeues", nodeName, c.queue.NumRequeues(nodeName))
+	c.queue.Forget(nodeName)
+	c.queue.Add(nodeName)
+	return nil
+}
+
+// GetAdminState return the paused deletions and the nodes whose instance is missing or which are being drained
+func GetAdminState() (AdminState, error) {
+	c, err := runningController()
+	if err != nil {
+		return AdminState{}, err
+	}
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return AdminState{}, err
+	}
+	state := AdminState{
+		Leader:         GetLeaderStatus(),
+		CircuitBreaker: breaker.status(),
+		QueuedNodes:    c.queue.Len(),
+		Nodes:          []AdminNodeState{},
+	}
+	state.PausedDeletions, state.PauseStateLoaded = pauses.get()
+	gracePeriod := config.Current().DeletionGracePeriod
+	for _, node := range nodes {
+		since, missing := missingSince(node)
+		drainStart, draining := drainStartedAt(node)
+		if !missing && !draining {
+			continue
+		}
+		nodeState := AdminNodeState{
+			Name:       node.Name,
+			ProviderID: node.Spec.ProviderID,
+			Ready:      nodeReady(node),
+			Requeues:   c.queue.NumRequeues(node.Name),
+		}
+		if missing {
+			endsAt := since.Add(gracePeriod)
+			nodeState.InstanceMissing, nodeState.GracePeriodEndsAt = &since, &endsAt
+		}
+		if draining {
+			nodeState.DrainStartedAt = &drainStart
+		}
+		state.Nodes = append(state.Nodes, nodeState)
+	}
+	sort.Slice(state.Nodes, func(i, j int) bool { return state.Nodes[i].Name < state.Nodes[j].Name })
+	return state, nil
+}
+
+// deletionsPaused whether an operator paused the deletions of the provider of the node, and why
+func deletionsPaused(providerID string) (bool, string) {
+	return pauses.paused(provider.NameOf(providerID))
+}
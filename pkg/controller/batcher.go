@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/provider"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"sync"
+	"time"
+)
+
+// prefetchTTL how long a prefetched status can be used, older ones are looked up again
+const prefetchTTL = 30 * time.Second
+
+// instanceBatcher coalesce the instance lookups of the workers into batched provider calls and fan the results back out,
+// a lookup waits at most window for other lookups to join its batch, a batch is sent as soon as it holds maxSize nodes.
+// A batch is shared by several lookups, its cloud call is bound to ctx, the controller context, and to the call timeout
+type instanceBatcher struct {
+	ctx     context.Context
+	api     provider.CloudAPI
+	window  time.Duration
+	maxSize int
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending []*instanceLookup
+	timer   *time.Timer
+
+	prefetched map[string]prefetchedResult // keyed by providerID
+}
+
+// instanceLookup a node waiting for the result of its batch
+type instanceLookup struct {
+	node   *corev1.Node
+	result chan types.InstanceResult
+}
+
+type prefetchedResult struct {
+	types.InstanceResult
+	fetchedAt time.Time
+}
+
+func newInstanceBatcher(ctx context.Context, api provider.CloudAPI, window time.Duration, maxSize int, timeout time.Duration) *instanceBatcher {
+	return &instanceBatcher{
+		ctx:        ctx,
+		api:        api,
+		window:     window,
+		maxSize:    maxSize,
+		timeout:    timeout,
+		prefetched: map[string]prefetchedResult{},
+	}
+}
+
+// GetInstanceStatus return the prefetched status of the node if any, otherwise look it up in the next batch,
+// the wait for the batch ends when ctx is done
+func (b *instanceBatcher) GetInstanceStatus(ctx context.Context, node *corev1.Node) (*types.InstanceStatus, error) {
+	batchAPI, ok := b.api.(provider.BatchCloudAPI)
+	if !ok {
+		callCtx, cancel := context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+		return b.api.GetInstanceStatus(callCtx, node)
+	}
+
+	lookup := &instanceLookup{node: node, result: make(chan types.InstanceResult, 1)}
+	b.mu.Lock()
+	if result, ok := b.takePrefetched(node.Spec.ProviderID); ok {
+		b.mu.Unlock()
+		return result.Status, result.Err
+	}
+	b.pending = append(b.pending, lookup)
+	var batch []*instanceLookup
+	if len(b.pending) >= b.maxSize || b.window <= 0 {
+		batch = b.takePending()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+	b.mu.Unlock()
+
+	if batch != nil {
+		go b.lookup(batchAPI, batch)
+	}
+	select {
+	case result := <-lookup.result:
+		return result.Status, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Prefetch look up the nodes in batches ahead of their processing, each result is used once by GetInstanceStatus
+func (b *instanceBatcher) Prefetch(nodes []*corev1.Node) {
+	batchAPI, ok := b.api.(provider.BatchCloudAPI)
+	if !ok || len(nodes) == 0 {
+		return
+	}
+	for len(nodes) > 0 {
+		chunk := nodes[:min(len(nodes), b.maxSize)]
+		nodes = nodes[len(chunk):]
+		results := b.getInstanceStatuses(batchAPI, chunk)
+		now := time.Now()
+		b.mu.Lock()
+		for providerID, result := range results {
+			b.prefetched[providerID] = prefetchedResult{InstanceResult: result, fetchedAt: now}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// takePrefetched consume the prefetched result of a providerID, dropping the expired ones
+func (b *instanceBatcher) takePrefetched(providerID string) (types.InstanceResult, bool) {
+	result, ok := b.prefetched[providerID]
+	if !ok {
+		return types.InstanceResult{}, false
+	}
+	delete(b.prefetched, providerID)
+	for id, r := range b.prefetched {
+		if time.Since(r.fetchedAt) > prefetchTTL {
+			delete(b.prefetched, id)
+		}
+	}
+	if time.Since(result.fetchedAt) > prefetchTTL {
+		return types.InstanceResult{}, false
+	}
+	return result.InstanceResult, true
+}
+
+// flush send the pending lookups once the window is over
+func (b *instanceBatcher) flush() {
+	b.mu.Lock()
+	batch := b.takePending()
+	b.mu.Unlock()
+	if batchAPI, ok := b.api.(provider.BatchCloudAPI); ok && len(batch) > 0 {
+		b.lookup(batchAPI, batch)
+	}
+}
+
+// takePending take the pending lookups out of the batcher, the caller holds the lock
+func (b *instanceBatcher) takePending() []*instanceLookup {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+// lookup send one batch and hand every node its result
+func (b *instanceBatcher) lookup(api provider.BatchCloudAPI, batch []*instanceLookup) {
+	nodes := make([]*corev1.Node, 0, len(batch))
+	for _, lookup := range batch {
+		nodes = append(nodes, lookup.node)
+	}
+	results := b.getInstanceStatuses(api, nodes)
+	for _, lookup := range batch {
+		result, ok := results[lookup.node.Spec.ProviderID]
+		if !ok {
+			result.Err = fmt.Errorf("no result for instance %s in the batch lookup", lookup.node.Spec.ProviderID)
+		}
+		lookup.result <- result
+	}
+}
+
+// getInstanceStatuses look up a batch within the call timeout
+func (b *instanceBatcher) getInstanceStatuses(api provider.BatchCloudAPI, nodes []*corev1.Node) types.InstanceResults {
+	ctx, cancel := context.WithTimeout(b.ctx, b.timeout)
+	defer cancel()
+	return api.GetInstanceStatuses(ctx, nodes)
+}
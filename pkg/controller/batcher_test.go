@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type fakeBatchAPI struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (f *fakeBatchAPI) GetInstanceStatus(_ context.Context, node *corev1.Node) (*types.InstanceStatus, error) {
+	return types.NewNotFoundStatus(node.Spec.ProviderID), nil
+}
+
+func (f *fakeBatchAPI) GetInstanceStatuses(_ context.Context, nodes []*corev1.Node) types.InstanceResults {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var batch []string
+	results := types.InstanceResults{}
+	for _, node := range nodes {
+		batch = append(batch, node.Spec.ProviderID)
+		results[node.Spec.ProviderID] = types.InstanceResult{Status: &types.InstanceStatus{State: types.InstanceRunning, ProviderID: node.Spec.ProviderID}}
+	}
+	f.batches = append(f.batches, batch)
+	return results
+}
+
+func testNode(providerID string) *corev1.Node {
+	return &corev1.Node{Spec: corev1.NodeSpec{ProviderID: providerID}}
+}
+
+func TestInstanceBatcherCoalescesLookups(t *testing.T) {
+	api := &fakeBatchAPI{}
+	b := newInstanceBatcher(context.Background(), api, time.Hour, 3, time.Minute)
+
+	var wg sync.WaitGroup
+	for _, providerID := range []string{"aws:///a/i-1", "aws:///a/i-2", "aws:///a/i-3"} {
+		wg.Add(1)
+		go func(providerID string) {
+			defer wg.Done()
+			status, err := b.GetInstanceStatus(context.Background(), testNode(providerID))
+			if err != nil || status.ProviderID != providerID || status.State != types.InstanceRunning {
+				t.Errorf("%s: unexpected result %+v, %v", providerID, status, err)
+			}
+		}(providerID)
+	}
+	wg.Wait()
+	if len(api.batches) != 1 || len(api.batches[0]) != 3 {
+		t.Errorf("expected the lookups to be sent in one batch once full, got %v", api.batches)
+	}
+}
+
+func TestInstanceBatcherWindow(t *testing.T) {
+	api := &fakeBatchAPI{}
+	b := newInstanceBatcher(context.Background(), api, 10*time.Millisecond, 100, time.Minute)
+
+	if _, err := b.GetInstanceStatus(context.Background(), testNode("aws:///a/i-1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(api.batches) != 1 {
+		t.Errorf("expected the batch to be sent once the window is over, got %v", api.batches)
+	}
+}
+
+func TestInstanceBatcherPrefetch(t *testing.T) {
+	api := &fakeBatchAPI{}
+	b := newInstanceBatcher(context.Background(), api, 0, 2, time.Minute)
+
+	b.Prefetch([]*corev1.Node{testNode("aws:///a/i-1"), testNode("aws:///a/i-2"), testNode("aws:///a/i-3")})
+	if len(api.batches) != 2 {
+		t.Fatalf("expected the prefetch to be split in batches of 2, got %v", api.batches)
+	}
+	if _, err := b.GetInstanceStatus(context.Background(), testNode("aws:///a/i-1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(api.batches) != 2 {
+		t.Errorf("expected the prefetched status to be used, got %v", api.batches)
+	}
+	if _, err := b.GetInstanceStatus(context.Background(), testNode("aws:///a/i-1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(api.batches) != 3 {
+		t.Errorf("expected a prefetched status to be used only once, got %v", api.batches)
+	}
+}
+
+// hangingBatchAPI a batch API that only returns once its call is cancelled
+type hangingBatchAPI struct {
+	fakeBatchAPI
+}
+
+func (f *hangingBatchAPI) GetInstanceStatuses(ctx context.Context, nodes []*corev1.Node) types.InstanceResults {
+	<-ctx.Done()
+	results := types.InstanceResults{}
+	for _, node := range nodes {
+		results[node.Spec.ProviderID] = types.InstanceResult{Err: ctx.Err()}
+	}
+	return results
+}
+
+func TestInstanceBatcherCallTimeout(t *testing.T) {
+	b := newInstanceBatcher(context.Background(), &hangingBatchAPI{}, 0, 100, 10*time.Millisecond)
+
+	if _, err := b.GetInstanceStatus(context.Background(), testNode("aws:///a/i-1")); err != context.DeadlineExceeded {
+		t.Errorf("expected the batch call to time out, got %v", err)
+	}
+}
+
+func TestInstanceBatcherCallerCancelled(t *testing.T) {
+	b := newInstanceBatcher(context.Background(), &hangingBatchAPI{}, 0, 100, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.GetInstanceStatus(ctx, testNode("aws:///a/i-1")); err != context.Canceled {
+		t.Errorf("expected the lookup to end with its context, got %v", err)
+	}
+}
@@ -2,27 +2,41 @@ package controller
 
 import (
 	"cloud-node-lifecycle-controller/pkg/client"
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/metrics"
+	"cloud-node-lifecycle-controller/pkg/provider"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
 	"context"
 	"fmt"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	listerv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	cloudnodeutil "k8s.io/cloud-provider/node/helpers"
 	"k8s.io/klog/v2"
 	"time"
 )
 
+// controllerName component name used for events
+const controllerName = "cloud-node-lifecycle-controller"
+
 // Controller is buffer-pool-controller struct
 type Controller struct {
 	ctx       context.Context
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
+	recorder  record.EventRecorder
+	eventRef  *corev1.ObjectReference // namespaced object holding a copy of the node events
+	instances *instanceBatcher
 
 	queue workqueue.TypedRateLimitingInterface[string]
 
@@ -30,18 +44,29 @@ type Controller struct {
 	nodeLister   listerv1.NodeLister
 }
 
-// CreateAndStartController create and start controller
-func CreateAndStartController(ctx context.Context, clientset *kubernetes.Clientset, stopCh chan struct{}) {
+// CreateAndStartController create and start controller, the node events are also recorded on eventRef
+func CreateAndStartController(ctx context.Context, clientset *kubernetes.Clientset, eventRef *corev1.ObjectReference, stopCh chan struct{}) {
+
+	queue := workqueue.NewTypedRateLimitingQueueWithConfig(workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "node"})
 
-	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]())
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(0)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
 
 	controller := &Controller{
 		ctx:       ctx,
 		clientset: clientset,
+		recorder:  broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerName}),
+		eventRef:  eventRef,
+		instances: newInstanceBatcher(ctx, client.CloudProviderAPI, config.Options.BatchWindow, config.Options.BatchSize, config.Options.CloudCallTimeout),
 		queue:     queue,
 	}
 
-	factory := informers.NewSharedInformerFactory(clientset, 0)
+	// only the nodes matching the node selector are watched
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = config.Options.NodeSelector
+	}))
 
 	controller.nodeInformer = factory.Core().V1().Nodes().Informer()
 	controller.nodeLister = factory.Core().V1().Nodes().Lister()
@@ -54,18 +79,29 @@ func CreateAndStartController(ctx context.Context, clientset *kubernetes.Clients
 			node := obj.(*corev1.Node)
 			queue.Add(node.Name)
 		},
+		DeleteFunc: func(obj interface{}) {
+			// a node deleted by someone else gives back the deletion it reserved
+			if name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+				breaker.release(name)
+			}
+		},
 	})
 	if err != nil {
 		klog.Fatalf("failed to add event handler: %v", err)
 		return
 	}
 
+	// deletions stay paused until the pauses and the circuit breaker of the previous leaders are loaded
+	go loadControllerState(ctx, clientset, eventRef.Namespace, eventRef.Name)
+	running.Store(controller)
+	health.controllerStarted(controller.nodeInformer.HasSynced, queue.Len, config.Options.Workers)
 	factory.Start(stopCh)
 
 	go controller.run(stopCh)
 }
 
 func (c *Controller) runWorker() {
+	defer health.workerStarted()()
 	for c.processNextItem() {
 	}
 }
@@ -75,6 +111,7 @@ func (c *Controller) processNextItem() bool {
 	if quit {
 		return false
 	}
+	health.taken()
 
 	err := func(obj string) error {
 		defer c.queue.Done(obj)
@@ -107,27 +144,11 @@ func (c *Controller) run(stopCh chan struct{}) {
 		return
 	}
 
-	for i := 0; i < 5; i++ {
+	for i := 0; i < config.Options.Workers; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
-	ticker := time.NewTicker(30 * time.Second)
-	go func() {
-		for range ticker.C {
-			nodeList, err := c.clientset.CoreV1().Nodes().List(c.ctx, metav1.ListOptions{})
-			if err != nil {
-				klog.Errorf("list node error:%v", err)
-				return
-			}
-			for _, node := range nodeList.Items {
-				err := c.processNode(&node)
-				if err != nil {
-					klog.Errorf("process node %s error:%v", node.Name, err)
-					return
-				}
-			}
-		}
-	}()
+	go wait.Until(c.resync, config.Options.ResyncPeriod, stopCh)
 
 	<-stopCh
 	klog.Info("Stopping Cloud Node Controller")
@@ -141,6 +162,7 @@ func (c *Controller) syncHandler(nodeName string) error {
 			return err
 		} else {
 			klog.Infof("node %s is not found", nodeName)
+			dryRun.forget(nodeName)
 			return nil
 		}
 
@@ -155,31 +177,234 @@ func (c *Controller) syncHandler(nodeName string) error {
 func (c *Controller) processNode(node *corev1.Node) error {
 	nodeName := node.Name
 
-	status := corev1.ConditionUnknown
-	if _, c := cloudnodeutil.GetNodeCondition(&node.Status, corev1.NodeReady); c != nil {
-		status = c.Status
+	if excluded, reason := nodeExcluded(node); excluded {
+		klog.V(4).Infof("ignore node %s: %s", nodeName, reason)
+		// a node excluded while it was drained gives its deletion back
+		breaker.release(nodeName)
+		dryRun.forget(nodeName)
+		return nil
+	}
+	if nodeReady(node) {
+		dryRun.forget(nodeName)
+		if err := c.untaintShutdownNode(node); err != nil {
+			return err
+		}
+		if err := c.cancelDrain(node); err != nil {
+			return err
+		}
+		return c.clearInstanceMissing(node)
+	}
+	if node.Spec.ProviderID == "" {
+		breaker.release(nodeName)
+		return nil
 	}
 
-	if status != corev1.ConditionTrue && node.Spec.ProviderID != "" {
-		klog.Infof("node %s is not ready, try to check machine status", nodeName)
-		existed, err := client.CloudProviderAPI.CheckNodeInstanceExists(node)
-		if err != nil {
+	klog.Infof("node %s is not ready, try to check machine status", nodeName)
+	providerName := provider.NameOf(node.Spec.ProviderID)
+	if wait := cloudHealth.backoff(providerName); wait > 0 {
+		klog.Infof("cloud provider %s is throttled, check node %s again in %s", providerName, nodeName, wait.Round(time.Second))
+		c.queue.AddAfter(nodeName, wait)
+		return nil
+	}
+	instance, err := c.instances.GetInstanceStatus(c.ctx, node)
+	if types.IsNotFound(err) {
+		// only an error the provider classified as not found tells the instance is gone
+		instance, err = types.NewNotFoundStatus(node.Spec.ProviderID), nil
+	}
+	if err != nil {
+		if types.IsUnsupportedProviderID(err) {
+			klog.Warningf("skip node %s: %v", nodeName, err)
+			metrics.NodeSkips.WithLabelValues(providerName, reasonUnsupportedProviderID).Inc()
+			c.recordNodeEvent(node, corev1.EventTypeWarning, reasonUnsupportedProviderID, "Node skipped: %v", err)
+			return nil
+		}
+		return c.handleProviderError(node, providerName, err)
+	}
+	c.providerSucceeded(providerName)
+	klog.Infof("node %s instance state: %s (%s)", nodeName, instance.State, instance.ProviderState)
+	metrics.NodeChecks.WithLabelValues(providerName, string(instance.State)).Inc()
+	if instanceGone(instance) {
+		return c.deleteNode(node, instance)
+	}
+	if !instanceExists(instance) {
+		// an ambiguous answer neither confirms nor denies the instance, the grace period and the drain go on
+		klog.Warningf("instance of node %s is in an unknown state (%s), keep its deletion state", nodeName, instance.ProviderState)
+		return nil
+	}
+	dryRun.forget(nodeName)
+	if instance.State == types.InstanceStopped {
+		if err := c.taintShutdownNode(node, instance.ProviderState); err != nil {
 			return err
 		}
-		if !existed {
-			klog.Infof("node %s is not existed on cloud,will delete it", nodeName)
-			if err := c.clientset.CoreV1().Nodes().Delete(context.TODO(), nodeName, metav1.DeleteOptions{}); err != nil {
-				if !errors.IsNotFound(err) {
-					klog.Errorf("delete node %s error: %v", nodeName, err)
-					return err
-				} else {
-					klog.Infof("node %s is not found", nodeName)
-					return nil
-				}
+	}
+	if err := c.cancelDrain(node); err != nil {
+		return err
+	}
+	return c.clearInstanceMissing(node)
+}
 
+// handleProviderError react to a failed instance lookup according to the kind of the error, the node is never deleted:
+// a throttled provider is paused for every node, an auth failure raises an alert until a call succeeds again,
+// a permanent error is retried on the next resync only and the other errors are retried with the rate limiter
+func (c *Controller) handleProviderError(node *corev1.Node, providerName string, err error) error {
+	kind := types.KindOf(err)
+	metrics.ProviderErrors.WithLabelValues(providerName, string(kind)).Inc()
+	metrics.NodeErrors.WithLabelValues(providerName, reasonProviderError).Inc()
+	switch kind {
+	case types.ErrorThrottled:
+		delay := cloudHealth.throttled(providerName, types.RetryAfter(err))
+		c.queue.AddAfter(node.Name, delay)
+		return nil
+	case types.ErrorAuthFailure:
+		if cloudHealth.authFailed(providerName, err) {
+			metrics.ProviderAuthFailure.WithLabelValues(providerName).Set(1)
+			c.recordControllerEvent(corev1.EventTypeWarning, reasonCloudAuthFailure,
+				"Cloud provider %s rejected the credentials of the controller: %v", providerName, err)
+		}
+	}
+	c.recordNodeEvent(node, corev1.EventTypeWarning, reasonProviderError, "Failed to get the status of instance %s (%s): %v", node.Spec.ProviderID, kind, err)
+	if kind == types.ErrorPermanent {
+		return nil
+	}
+	return err
+}
+
+// providerSucceeded reset the throttling backoff of the provider and clear its auth failure alert
+func (c *Controller) providerSucceeded(providerName string) {
+	if cloudHealth.succeeded(providerName) {
+		metrics.ProviderAuthFailure.WithLabelValues(providerName).Set(0)
+		c.recordControllerEvent(corev1.EventTypeNormal, reasonCloudAuthRecovered,
+			"Cloud provider %s accepts the credentials of the controller again", providerName)
+	}
+}
+
+// prefetchInstances look up in batches the instances of the nodes processNode will check,
+// except the ones of the throttled providers
+func (c *Controller) prefetchInstances(nodes []*corev1.Node) {
+	var candidates []*corev1.Node
+	for _, node := range nodes {
+		providerID := node.Spec.ProviderID
+		if excluded, _ := nodeExcluded(node); excluded {
+			continue
+		}
+		if providerID != "" && !nodeReady(node) && cloudHealth.backoff(provider.NameOf(providerID)) == 0 {
+			candidates = append(candidates, node)
+		}
+	}
+	c.instances.Prefetch(candidates)
+}
+
+// nodeReady whether the Ready condition of the node is true
+func nodeReady(node *corev1.Node) bool {
+	_, condition := cloudnodeutil.GetNodeCondition(&node.Status, corev1.NodeReady)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
+
+// deleteNode delete a node whose instance is gone, once the grace period is over and the circuit breaker allows it,
+// the deletion is reserved before the node is drained. A dry run goes through the same gates and stops before the drain
+// and the delete
+func (c *Controller) deleteNode(node *corev1.Node, instance *types.InstanceStatus) error {
+	nodeName := node.Name
+	providerName := provider.NameOf(node.Spec.ProviderID)
+	reason := "Instance" + string(instance.State)
+	c.recordNodeEvent(node, corev1.EventTypeWarning, reasonInstanceNotFound, "Instance %s is %s (%s)", node.Spec.ProviderID, instance.State, instance.ProviderState)
+	if expired, err := c.gracePeriodExpired(node); err != nil || !expired {
+		if err == nil {
+			metrics.NodeSkips.WithLabelValues(providerName, "GracePeriod").Inc()
+		}
+		return err
+	}
+	if paused, reason := deletionsPaused(node.Spec.ProviderID); paused {
+		klog.Warningf("skip deleting node %s: %s", nodeName, reason)
+		metrics.NodeSkips.WithLabelValues(providerName, "Paused").Inc()
+		c.recordNodeEvent(node, corev1.EventTypeWarning, reasonDeletionSkipped, "Deletion skipped: %s", reason)
+		return nil
+	}
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	// the excluded nodes are never deleted, they don't count in the deletion percentage
+	totalNodes := nodesInScope(nodes)
+	if config.Current().DryRun {
+		// the deletions of a dry run are counted by the shadow breaker of the report, the real breaker is only checked
+		tripped, err := false, breaker.allow(totalNodes)
+		if err == nil {
+			tripped, err = dryRun.count(nodeName, totalNodes)
+		}
+		if err != nil {
+			if tripped {
+				c.recordNodeEvent(node, corev1.EventTypeWarning, reasonCircuitBreakerTripped,
+					"Deletion of node %s would be blocked and all node deletions halted: %v (dry-run)", nodeName, err)
+			} else {
+				c.recordNodeEvent(node, corev1.EventTypeWarning, reasonDeletionSkipped, "Deletion skipped by the circuit breaker: %v (dry-run)", err)
 			}
-			klog.Infof("delete node %s success", nodeName)
+			klog.Warningf("[dry-run] skip deleting node %s: %v", nodeName, err)
+			metrics.NodeSkips.WithLabelValues(providerName, "CircuitBreaker").Inc()
+			return nil
 		}
+		klog.Infof("[dry-run] node %s is not existed on cloud, would delete it", nodeName)
+		metrics.NodeSkips.WithLabelValues(providerName, "DryRun").Inc()
+		dryRun.record(node, reason)
+		c.recordNodeEvent(node, corev1.EventTypeWarning, reasonDryRunDeletion,
+			"Node %s would be deleted: instance %s is %s (dry-run)", nodeName, node.Spec.ProviderID, instance.State)
+		return nil
 	}
+	// a node resumed by a new leader reserves again, its drain is held while the breaker refuses it
+	if tripped, err := breaker.reserve(nodeName, totalNodes); err != nil {
+		if tripped {
+			c.recordNodeEvent(node, corev1.EventTypeWarning, reasonCircuitBreakerTripped,
+				"Deletion of node %s blocked and all node deletions halted: %v", nodeName, err)
+		} else {
+			c.recordNodeEvent(node, corev1.EventTypeWarning, reasonDeletionSkipped, "Deletion skipped by the circuit breaker: %v", err)
+		}
+		klog.Warningf("skip deleting node %s: %v", nodeName, err)
+		metrics.NodeSkips.WithLabelValues(providerName, "CircuitBreaker").Inc()
+		// a new leader keeps the deletions halted, the save is retried by the next halted deletion if it fails
+		if err := breaker.save(c.ctx); err != nil {
+			klog.Errorf("save the tripped circuit breaker error: %v", err)
+		}
+		return nil
+	}
+	// the reservation is kept while the node drains and until the delete goes through
+	if config.Current().DrainBeforeDelete {
+		if drained, err := c.drainNode(node); err != nil || !drained {
+			return err
+		}
+	}
+	klog.Infof("node %s is not existed on cloud,will delete it", nodeName)
+	if err := c.clientset.CoreV1().Nodes().Delete(c.ctx, nodeName, metav1.DeleteOptions{}); err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("delete node %s error: %v", nodeName, err)
+			metrics.NodeErrors.WithLabelValues(providerName, reasonDeleteError).Inc()
+			c.recordNodeEvent(node, corev1.EventTypeWarning, reasonDeleteError, "Failed to delete node: %v", err)
+			return err
+		} else {
+			klog.Infof("node %s is not found", nodeName)
+			breaker.release(nodeName)
+			return nil
+		}
+
+	}
+	klog.Infof("delete node %s success", nodeName)
+	breaker.commit(nodeName)
+	c.recordNodeEvent(node, corev1.EventTypeNormal, reasonNodeDeleted, "Node deleted, instance %s is %s", node.Spec.ProviderID, instance.State)
+	metrics.NodeDeletions.WithLabelValues(providerName, reason).Inc()
 	return nil
 }
+
+// instanceExists whether the instance backing a node is known to exist, running or stopped
+func instanceExists(instance *types.InstanceStatus) bool {
+	return instance.State == types.InstanceRunning || instance.State == types.InstanceStopped
+}
+
+// instanceGone whether the instance backing a node is gone for good and the node can be deleted,
+// stopped and unknown instances keep their node
+func instanceGone(instance *types.InstanceStatus) bool {
+	switch instance.State {
+	case types.InstanceNotFound, types.InstanceTerminated, types.InstanceTerminating:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testQueue workqueue recording the delayed requeues
+type testQueue struct {
+	workqueue.TypedRateLimitingInterface[string]
+	mu    sync.Mutex
+	after map[string]time.Duration
+}
+
+func (q *testQueue) AddAfter(item string, duration time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.after[item] = duration
+}
+
+func (q *testQueue) requeuedAfter(item string) (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	duration, ok := q.after[item]
+	return duration, ok
+}
+
+// newTestController controller on a fake cluster holding the objects, the nodes are also in the lister.
+// The circuit breaker, the pauses, the saved state and the dry-run report are reset
+func newTestController(t *testing.T, o *option.Options, objects ...runtime.Object) (*Controller, *fake.Clientset, *testQueue) {
+	t.Helper()
+	config.Options = o
+	breaker = newCircuitBreaker()
+	pauses = &pauseStore{loaded: true}
+	controllerState = &stateStore{}
+	dryRun = newDryRunRecorder()
+
+	clientset := fake.NewSimpleClientset(objects...)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, object := range objects {
+		if node, ok := object.(*corev1.Node); ok {
+			if err := indexer.Add(node); err != nil {
+				t.Fatalf("add node: %v", err)
+			}
+		}
+	}
+	queue := &testQueue{
+		TypedRateLimitingInterface: workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		after:                      map[string]time.Duration{},
+	}
+	t.Cleanup(queue.ShutDown)
+	c := &Controller{
+		ctx:        context.Background(),
+		clientset:  clientset,
+		recorder:   &record.FakeRecorder{},
+		queue:      queue,
+		nodeLister: listerv1.NewNodeLister(indexer),
+	}
+	return c, clientset, queue
+}
+
+func notReadyNode(name string, annotations map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+		Spec:       corev1.NodeSpec{ProviderID: "aws:///us-west-2a/" + name},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionUnknown},
+		}},
+	}
+}
+
+func readyNode(name string) *corev1.Node {
+	node := notReadyNode(name, nil)
+	node.Status.Conditions[0].Status = corev1.ConditionTrue
+	return node
+}
+
+// getNode the node as stored in the fake cluster, nil if it was deleted
+func getNode(t *testing.T, clientset *fake.Clientset, name string) *corev1.Node {
+	t.Helper()
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+	return node
+}
+
+var notFound = &types.InstanceStatus{State: types.InstanceNotFound}
+
+func TestDryRunGoesThroughTheGates(t *testing.T) {
+	missing := map[string]string{annotationInstanceMissingSince: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}
+	cases := []struct {
+		name      string
+		options   option.Options
+		paused    bool
+		annotated bool
+		candidate bool
+	}{
+		{name: "deletable", options: option.Options{DryRun: true, DeletionInterval: time.Minute}, candidate: true},
+		{name: "in grace period", options: option.Options{DryRun: true, DeletionInterval: time.Minute, DeletionGracePeriod: time.Hour}},
+		{name: "grace period over", options: option.Options{DryRun: true, DeletionInterval: time.Minute, DeletionGracePeriod: time.Minute}, annotated: true, candidate: true},
+		{name: "paused", options: option.Options{DryRun: true, DeletionInterval: time.Minute}, paused: true},
+		{name: "blocked by the breaker", options: option.Options{DryRun: true, DeletionInterval: time.Minute, MaxDeletionPercentage: 10}},
+	}
+	for _, tc := range cases {
+		var annotations map[string]string
+		if tc.annotated {
+			annotations = missing
+		}
+		c, clientset, _ := newTestController(t, &tc.options, notReadyNode("node-1", annotations), readyNode("node-2"))
+		if tc.paused {
+			pauses.state.All = &Pause{Since: time.Now()}
+		}
+		node, _ := c.nodeLister.Get("node-1")
+		if err := c.deleteNode(node, notFound); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if getNode(t, clientset, "node-1") == nil {
+			t.Errorf("%s: expected a dry run never to delete the node", tc.name)
+		}
+		if report := dryRun.report(); (len(report.Candidates) == 1) != tc.candidate {
+			t.Errorf("%s: expected candidate %v, got %+v", tc.name, tc.candidate, report.Candidates)
+		}
+	}
+}
+
+func TestDeletionPercentageCountsNodesInScope(t *testing.T) {
+	o := &option.Options{MaxDeletionPercentage: 50, DeletionInterval: time.Hour}
+	controlPlane := func(name string) *corev1.Node {
+		node := readyNode(name)
+		node.Labels = map[string]string{labelControlPlane: ""}
+		return node
+	}
+	// 1 of 2 workers is 50%, 1 of 4 nodes would let a second worker go
+	c, clientset, _ := newTestController(t, o, notReadyNode("node-1", nil), notReadyNode("node-2", nil), controlPlane("cp-1"), controlPlane("cp-2"))
+
+	for _, name := range []string{"node-1", "node-2"} {
+		node, _ := c.nodeLister.Get(name)
+		if err := c.deleteNode(node, notFound); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if getNode(t, clientset, "node-1") != nil {
+		t.Errorf("expected node-1 to be deleted")
+	}
+	if getNode(t, clientset, "node-2") == nil {
+		t.Errorf("expected the control-plane nodes not to count in the deletion percentage")
+	}
+}
+
+func TestDryRunCountsCandidatesInShadowBreaker(t *testing.T) {
+	o := &option.Options{DryRun: true, MaxDeletionsPerInterval: 2, DeletionInterval: time.Hour}
+	c, _, _ := newTestController(t, o, notReadyNode("node-1", nil), notReadyNode("node-2", nil), notReadyNode("node-3", nil))
+
+	for _, name := range []string{"node-1", "node-1", "node-2", "node-3"} {
+		node, _ := c.nodeLister.Get(name)
+		if err := c.deleteNode(node, notFound); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	report := dryRun.report()
+	if len(report.Candidates) != 2 || report.CircuitBreaker.RecentDeletions != 2 {
+		t.Errorf("expected each candidate to be counted once, got %+v", report)
+	}
+	if !report.CircuitBreaker.Tripped || report.CircuitBreaker.TrippedAt == nil {
+		t.Errorf("expected the report to show the breaker would trip on node-3, got %+v", report.CircuitBreaker)
+	}
+	if status := breaker.status(); status.Tripped || status.RecentDeletions != 0 {
+		t.Errorf("expected a dry run never to count in the real breaker, got %+v", status)
+	}
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// drain state of a node, kept on the node so a new leader resumes the drain where it stopped
+const (
+	annotationDrainStartedAt = "node-lifecycle.io/drain-started-at"
+	annotationDrainCordoned  = "node-lifecycle.io/drain-cordoned"
+)
+
+// drainRetryInterval how often pods are evicted again while a drain is in progress
+const drainRetryInterval = 10 * time.Second
+
+// drainStartedAt return when the drain of the node started, false if no drain is in progress
+func drainStartedAt(node *corev1.Node) (time.Time, bool) {
+	value, ok := node.Annotations[annotationDrainStartedAt]
+	if !ok {
+		return time.Time{}, false
+	}
+	startedAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		klog.Warningf("node %s has invalid %s annotation %q: %v", node.Name, annotationDrainStartedAt, value, err)
+		return time.Time{}, false
+	}
+	return startedAt, true
+}
+
+// drainNode cordon the node and evict its pods before it is deleted, it returns true once the node can be deleted.
+// Evictions go through the Eviction API so PodDisruptionBudgets are respected, the pods left after the drain timeout
+// are force deleted since the kubelet of the node is gone and will never confirm their termination.
+// The caller holds the deletion reserved in the circuit breaker, so the pods are only disrupted for a node that will be deleted
+func (c *Controller) drainNode(node *corev1.Node) (bool, error) {
+	startedAt, draining := drainStartedAt(node)
+	if !draining {
+		startedAt = time.Now()
+		if err := c.startDrain(node, startedAt); err != nil {
+			return false, err
+		}
+		c.recordNodeEvent(node, corev1.EventTypeNormal, reasonDrainStarted, "Node cordoned, evicting its pods before deleting it")
+	}
+
+	pods, err := c.clientset.CoreV1().Pods(metav1.NamespaceAll).List(c.ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.Name).String(),
+	})
+	if err != nil {
+		return false, err
+	}
+	remaining := podsToDrain(pods.Items)
+	if len(remaining) == 0 {
+		klog.Infof("node %s drained", node.Name)
+		c.recordNodeEvent(node, corev1.EventTypeNormal, reasonDrainCompleted, "All pods evicted")
+		return true, nil
+	}
+
+	if timeout := config.Current().DrainTimeout; time.Since(startedAt) >= timeout {
+		klog.Warningf("drain of node %s timed out after %s, force delete %d pods", node.Name, timeout, len(remaining))
+		if err := c.forceDeletePods(remaining); err != nil {
+			return false, err
+		}
+		c.recordNodeEvent(node, corev1.EventTypeWarning, reasonDrainTimedOut, "Drain timed out after %s, %d pods force deleted", timeout, len(remaining))
+		return true, nil
+	}
+
+	blocked := 0
+	for i := range remaining {
+		pod := &remaining[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if err := c.evictPod(pod); err != nil {
+			if !errors.IsTooManyRequests(err) {
+				return false, fmt.Errorf("evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+			blocked++
+		}
+	}
+	klog.Infof("node %s is draining, %d pods left, %d blocked by disruption budgets", node.Name, len(remaining), blocked)
+	c.queue.AddAfter(node.Name, drainRetryInterval)
+	return false, nil
+}
+
+// startDrain cordon the node and record the drain start in a single patch
+func (c *Controller) startDrain(node *corev1.Node, startedAt time.Time) error {
+	annotations := map[string]interface{}{
+		annotationDrainStartedAt: startedAt.UTC().Format(time.RFC3339),
+	}
+	// only uncordon the nodes cordoned by the controller if the drain is cancelled
+	if !node.Spec.Unschedulable {
+		annotations[annotationDrainCordoned] = "true"
+	}
+	klog.Infof("start draining node %s", node.Name)
+	return c.patchNode(node.Name, map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+		"spec":     map[string]interface{}{"unschedulable": true},
+	})
+}
+
+// cancelDrain clear the drain state of a node whose instance came back, and uncordon it if the drain cordoned it.
+// The deletion reserved for the node is given back
+func (c *Controller) cancelDrain(node *corev1.Node) error {
+	breaker.release(node.Name)
+	if _, ok := node.Annotations[annotationDrainStartedAt]; !ok {
+		return nil
+	}
+	if config.Current().DryRun {
+		klog.Infof("[dry-run] instance of node %s is back, would cancel its drain", node.Name)
+		return nil
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{
+			annotationDrainStartedAt: nil,
+			annotationDrainCordoned:  nil,
+		}},
+	}
+	if node.Annotations[annotationDrainCordoned] == "true" {
+		patch["spec"] = map[string]interface{}{"unschedulable": false}
+	}
+	klog.Infof("instance of node %s is back, cancel its drain", node.Name)
+	if err := c.patchNode(node.Name, patch); err != nil {
+		return err
+	}
+	c.recordNodeEvent(node, corev1.EventTypeNormal, reasonDrainCancelled, "Instance %s is back, drain cancelled", node.Spec.ProviderID)
+	return nil
+}
+
+// evictPod evict a pod through the Eviction API, a pod protected by a disruption budget returns a TooManyRequests error
+func (c *Controller) evictPod(pod *corev1.Pod) error {
+	err := c.clientset.CoreV1().Pods(pod.Namespace).EvictV1(c.ctx, &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// forceDeletePods delete the pods immediately, without waiting for the kubelet
+func (c *Controller) forceDeletePods(pods []corev1.Pod) error {
+	var gracePeriod int64
+	for _, pod := range pods {
+		err := c.clientset.CoreV1().Pods(pod.Namespace).Delete(c.ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("force delete pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// podsToDrain filter the pods that must leave the node, DaemonSet and mirror pods are bound to the node
+// and finished pods hold no workload
+func podsToDrain(pods []corev1.Pod) []corev1.Pod {
+	var result []corev1.Pod
+	for _, pod := range pods {
+		if _, mirror := pod.Annotations[corev1.MirrorPodAnnotationKey]; mirror {
+			continue
+		}
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owner.Kind == "DaemonSet" {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		result = append(result, pod)
+	}
+	return result
+}
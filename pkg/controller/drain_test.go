@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/option"
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func TestPodsToDrain(t *testing.T) {
+	isController := true
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "mirror", Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "hash"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "daemon", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent", Controller: &isController},
+		}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "replica", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web", Controller: &isController},
+		}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "done"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+	}
+
+	result := podsToDrain(pods)
+	if len(result) != 2 || result[0].Name != "app" || result[1].Name != "replica" {
+		var names []string
+		for _, pod := range result {
+			names = append(names, pod.Name)
+		}
+		t.Errorf("expected pods app and replica to be drained, got %v", names)
+	}
+}
+
+func podOn(node, name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+}
+
+func podExists(clientset *fake.Clientset, name string) bool {
+	_, err := clientset.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+	return err == nil
+}
+
+func TestDrainReservesTheDeletion(t *testing.T) {
+	o := &option.Options{DrainBeforeDelete: true, DrainTimeout: 5 * time.Minute, MaxDeletionsPerInterval: 1, DeletionInterval: time.Hour}
+	// the fake clientset ignores field selectors, only the first node holds pods
+	c, clientset, queue := newTestController(t, o, notReadyNode("node-1", nil), notReadyNode("node-2", nil), podOn("node-1", "app"))
+
+	node, _ := c.nodeLister.Get("node-1")
+	if err := c.deleteNode(node, notFound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	drained := getNode(t, clientset, "node-1")
+	if drained == nil || !drained.Spec.Unschedulable || drained.Annotations[annotationDrainStartedAt] == "" {
+		t.Fatalf("expected node-1 to be cordoned and draining, got %+v", drained)
+	}
+	if _, ok := queue.requeuedAfter("node-1"); !ok {
+		t.Errorf("expected the drain to be checked again")
+	}
+	if pending := breaker.status().PendingDeletions; pending != 1 {
+		t.Errorf("expected the drain to reserve its deletion, got %d pending", pending)
+	}
+
+	node, _ = c.nodeLister.Get("node-2")
+	if err := c.deleteNode(node, notFound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if refused := getNode(t, clientset, "node-2"); refused.Spec.Unschedulable {
+		t.Errorf("expected node-2 not to be drained while node-1 holds the only deletion")
+	}
+
+	if err := c.cancelDrain(drained); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if node := getNode(t, clientset, "node-1"); node.Spec.Unschedulable {
+		t.Errorf("expected the cancelled drain to uncordon node-1")
+	}
+	if pending := breaker.status().PendingDeletions; pending != 0 {
+		t.Errorf("expected the cancelled drain to release its deletion, got %d pending", pending)
+	}
+}
+
+func TestTimedOutDrainForceDeletesOnlyWithReservation(t *testing.T) {
+	o := &option.Options{DrainBeforeDelete: true, DrainTimeout: time.Minute, MaxDeletionsPerInterval: 1, DeletionInterval: time.Hour}
+	draining := notReadyNode("node-1", map[string]string{annotationDrainStartedAt: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)})
+	draining.Spec.Unschedulable = true
+	c, clientset, _ := newTestController(t, o, draining, podOn("node-1", "app"))
+
+	// a new leader resumes the drain with the breaker tripped by the previous one
+	breaker.trip(time.Now(), "test")
+	node, _ := c.nodeLister.Get("node-1")
+	if err := c.deleteNode(node, notFound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !podExists(clientset, "app") || getNode(t, clientset, "node-1") == nil {
+		t.Fatalf("expected the pods and the node to be kept while the breaker refuses the deletion")
+	}
+
+	breaker.reset()
+	if err := c.deleteNode(node, notFound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if podExists(clientset, "app") {
+		t.Errorf("expected the pods left after the drain timeout to be force deleted")
+	}
+	if getNode(t, clientset, "node-1") != nil {
+		t.Errorf("expected the drained node to be deleted")
+	}
+	if status := breaker.status(); status.RecentDeletions != 1 || status.PendingDeletions != 0 {
+		t.Errorf("expected the deletion to be counted once, got %+v", status)
+	}
+}
+
+func TestDeleteNodeGatesComeBeforeTheDrain(t *testing.T) {
+	cases := []struct {
+		name    string
+		options option.Options
+		paused  bool
+		tripped bool
+		deleted bool
+	}{
+		{name: "deletable", options: option.Options{}, deleted: true},
+		{name: "paused", options: option.Options{}, paused: true},
+		{name: "breaker tripped", options: option.Options{}, tripped: true},
+		{name: "dry run", options: option.Options{DryRun: true}},
+		{name: "in grace period", options: option.Options{DeletionGracePeriod: time.Hour}},
+	}
+	for _, tc := range cases {
+		tc.options.DrainBeforeDelete, tc.options.DrainTimeout, tc.options.DeletionInterval = true, time.Minute, time.Minute
+		c, clientset, _ := newTestController(t, &tc.options, notReadyNode("node-1", nil))
+		if tc.paused {
+			pauses.state.All = &Pause{Since: time.Now()}
+		}
+		if tc.tripped {
+			breaker.trip(time.Now(), "test")
+		}
+		node, _ := c.nodeLister.Get("node-1")
+		if err := c.deleteNode(node, notFound); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		node = getNode(t, clientset, "node-1")
+		if deleted := node == nil; deleted != tc.deleted {
+			t.Errorf("%s: expected deleted %v, got %v", tc.name, tc.deleted, deleted)
+		}
+		if node != nil && node.Spec.Unschedulable {
+			t.Errorf("%s: expected the node not to be cordoned", tc.name)
+		}
+	}
+}
+
+func TestExcludedNodeReleasesItsDeletion(t *testing.T) {
+	o := &option.Options{DrainBeforeDelete: true, DrainTimeout: 5 * time.Minute, MaxDeletionsPerInterval: 1, DeletionInterval: time.Hour}
+	c, _, _ := newTestController(t, o, notReadyNode("node-1", nil), podOn("node-1", "app"))
+
+	node, _ := c.nodeLister.Get("node-1")
+	if err := c.deleteNode(node, notFound); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pending := breaker.status().PendingDeletions; pending != 1 {
+		t.Fatalf("expected the drain to reserve its deletion, got %d pending", pending)
+	}
+	node = node.DeepCopy()
+	node.Annotations = map[string]string{annotationSkip: "true"}
+	if err := c.processNode(node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pending := breaker.status().PendingDeletions; pending != 0 {
+		t.Errorf("expected the excluded node to release its deletion, got %d pending", pending)
+	}
+}
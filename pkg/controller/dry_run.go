@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DryRunCandidate node that would have been deleted if dry-run was disabled
+type DryRunCandidate struct {
+	NodeName     string     `json:"nodeName"`
+	ProviderID   string     `json:"providerID"`
+	Reason       string     `json:"reason"`
+	MissingSince *time.Time `json:"missingSince,omitempty"`
+	FirstSeen    time.Time  `json:"firstSeen"`
+	LastSeen     time.Time  `json:"lastSeen"`
+	Count        int64      `json:"count"`
+}
+
+// DryRunReport snapshot of the dry-run candidates, and of the circuit breaker counting them as if they were deleted
+type DryRunReport struct {
+	Enabled        bool                 `json:"enabled"`
+	Total          int64                `json:"total"`
+	Candidates     []DryRunCandidate    `json:"candidates"`
+	CircuitBreaker CircuitBreakerStatus `json:"circuitBreaker"`
+}
+
+// dryRunRecorder keeps the would-be deletions observed in dry-run mode, and the time the instances were first seen missing
+// since a dry run never annotates the nodes
+type dryRunRecorder struct {
+	mu         sync.Mutex
+	total      int64
+	candidates map[string]*DryRunCandidate
+	missing    map[string]time.Time
+	breaker    *circuitBreaker // shadow breaker counting each candidate once, never saved
+}
+
+var dryRun = newDryRunRecorder()
+
+func newDryRunRecorder() *dryRunRecorder {
+	shadow := newCircuitBreaker()
+	shadow.shadow = true
+	return &dryRunRecorder{candidates: map[string]*DryRunCandidate{}, breaker: shadow}
+}
+
+// count the deletion of a new candidate in the shadow breaker, it returns the error the breaker would have refused
+// the deletion with. tripped is true only for the call that tripped the shadow breaker
+func (d *dryRunRecorder) count(nodeName string, totalNodes int) (tripped bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.candidates[nodeName]; ok {
+		return false, nil
+	}
+	if tripped, err := d.breaker.reserve(nodeName, totalNodes); err != nil {
+		return tripped, err
+	}
+	d.breaker.commit(nodeName)
+	return false, nil
+}
+
+// record add or refresh a candidate and bump the would-be deletion counter
+func (d *dryRunRecorder) record(node *corev1.Node, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.total++
+	candidate, ok := d.candidates[node.Name]
+	if !ok {
+		candidate = &DryRunCandidate{
+			NodeName:  node.Name,
+			FirstSeen: now,
+		}
+		d.candidates[node.Name] = candidate
+	}
+	candidate.ProviderID = node.Spec.ProviderID
+	candidate.Reason = reason
+	candidate.MissingSince = nil
+	if since, ok := d.missing[node.Name]; ok {
+		candidate.MissingSince = &since
+	}
+	candidate.LastSeen = now
+	candidate.Count++
+}
+
+// forget drop a candidate whose instance came back or whose node is gone
+func (d *dryRunRecorder) forget(nodeName string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.candidates, nodeName)
+	delete(d.missing, nodeName)
+}
+
+// markMissing keep in memory when the instance of the node was first seen missing, the dry-run counterpart of the annotation
+func (d *dryRunRecorder) markMissing(nodeName string, since time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.missing == nil {
+		d.missing = map[string]time.Time{}
+	}
+	d.missing[nodeName] = since
+}
+
+// missingSince return when the instance of the node was first seen missing during the dry run
+func (d *dryRunRecorder) missingSince(nodeName string) (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	since, ok := d.missing[nodeName]
+	return since, ok
+}
+
+func (d *dryRunRecorder) report() DryRunReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	report := DryRunReport{
+		Total:          d.total,
+		Candidates:     make([]DryRunCandidate, 0, len(d.candidates)),
+		CircuitBreaker: d.breaker.status(),
+	}
+	for _, candidate := range d.candidates {
+		report.Candidates = append(report.Candidates, *candidate)
+	}
+	sort.Slice(report.Candidates, func(i, j int) bool {
+		return report.Candidates[i].NodeName < report.Candidates[j].NodeName
+	})
+	return report
+}
+
+// GetDryRunReport return the nodes that would have been deleted in dry-run mode, the report is kept by the leader
+func GetDryRunReport() (DryRunReport, error) {
+	if _, err := runningController(); err != nil {
+		return DryRunReport{}, err
+	}
+	report := dryRun.report()
+	if o := config.Current(); o != nil {
+		report.Enabled = o.DryRun
+	}
+	return report, nil
+}
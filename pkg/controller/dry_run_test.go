@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"testing"
+)
+
+func TestDryRunRecorder(t *testing.T) {
+	d := newDryRunRecorder()
+	d.record(notReadyNode("node-b", nil), "InstanceNotFound")
+	d.record(notReadyNode("node-a", nil), "InstanceTerminated")
+	d.record(notReadyNode("node-b", nil), "InstanceNotFound")
+
+	report := d.report()
+	if report.Total != 3 || len(report.Candidates) != 2 {
+		t.Fatalf("expected 3 would-be deletions of 2 nodes, got %+v", report)
+	}
+	if report.Candidates[0].NodeName != "node-a" || report.Candidates[1].Count != 2 {
+		t.Errorf("expected the candidates sorted by name with their count, got %+v", report.Candidates)
+	}
+
+	d.forget("node-b")
+	if report := d.report(); report.Total != 3 || len(report.Candidates) != 1 {
+		t.Errorf("expected a forgotten node to leave the candidates but not the total, got %+v", report)
+	}
+}
+
+func TestDryRunReportOnlyOnLeader(t *testing.T) {
+	running.Store(nil)
+	if _, err := GetDryRunReport(); err != ErrNotLeader {
+		t.Errorf("expected the report to be served by the leader only, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// event reasons, they are part of the controller interface and matched by alerting tools, don't rename them
+const (
+	reasonInstanceNotFound      = "InstanceNotFound"
+	reasonProviderError         = "ProviderError"
+	reasonGracePeriodStarted    = "GracePeriodStarted"
+	reasonGracePeriodCancelled  = "GracePeriodCancelled"
+	reasonDeletionSkipped       = "DeletionSkipped"
+	reasonNodeDeleted           = "NodeDeleted"
+	reasonDryRunDeletion        = "DryRunDeletion"
+	reasonCircuitBreakerTripped = "DeletionCircuitBreakerTripped"
+	reasonNodeShutdown          = "NodeShutdown"
+	reasonNodeStarted           = "NodeStarted"
+	reasonUnsupportedProviderID = "UnsupportedProviderID"
+	reasonDeleteError           = "DeleteError"
+	reasonDrainStarted          = "DrainStarted"
+	reasonDrainCompleted        = "DrainCompleted"
+	reasonDrainTimedOut         = "DrainTimedOut"
+	reasonDrainCancelled        = "DrainCancelled"
+	reasonCloudAuthFailure      = "CloudAuthFailure"
+	reasonCloudAuthRecovered    = "CloudAuthRecovered"
+)
+
+// recordNodeEvent record an event on the node, and a copy on the controller namespaced object
+// so the lifecycle of the node can still be traced after it is deleted
+func (c *Controller) recordNodeEvent(node *corev1.Node, eventType, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	c.recorder.Event(node, eventType, reason, message)
+	if c.eventRef != nil {
+		c.recorder.Event(c.eventRef, eventType, reason, fmt.Sprintf("Node %s: %s", node.Name, message))
+	}
+}
+
+// recordControllerEvent record an event about the controller itself on the controller namespaced object
+func (c *Controller) recordControllerEvent(eventType, reason, messageFmt string, args ...interface{}) {
+	if c.eventRef != nil {
+		c.recorder.Eventf(c.eventRef, eventType, reason, messageFmt, args...)
+	}
+}
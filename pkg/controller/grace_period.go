@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"encoding/json"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// annotationInstanceMissingSince records when the instance of the node was first reported missing
+const annotationInstanceMissingSince = "node-lifecycle.io/instance-missing-since"
+
+// missingSince return when the instance of the node was first seen missing, false if the node is not marked
+func missingSince(node *corev1.Node) (time.Time, bool) {
+	value, ok := node.Annotations[annotationInstanceMissingSince]
+	if !ok {
+		return time.Time{}, false
+	}
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		klog.Warningf("node %s has invalid %s annotation %q: %v", node.Name, annotationInstanceMissingSince, value, err)
+		return time.Time{}, false
+	}
+	return since, true
+}
+
+// gracePeriodExpired check whether the instance has been missing for the whole deletion grace period,
+// the first time the instance is seen missing the node is marked and re-checked once the window is over
+func (c *Controller) gracePeriodExpired(node *corev1.Node) (bool, error) {
+	o := config.Current()
+	gracePeriod := o.DeletionGracePeriod
+	if gracePeriod <= 0 {
+		return true, nil
+	}
+	since, marked := missingSince(node)
+	if !marked && o.DryRun {
+		since, marked = dryRun.missingSince(node.Name)
+	}
+	if !marked {
+		if o.DryRun {
+			// a dry run keeps the mark in memory, the node is left untouched
+			klog.Infof("[dry-run] instance of node %s is missing, would mark it and wait %s before deleting it", node.Name, gracePeriod)
+			dryRun.markMissing(node.Name, time.Now())
+			c.queue.AddAfter(node.Name, gracePeriod)
+			return false, nil
+		}
+		klog.Infof("instance of node %s is missing, wait %s before deleting it", node.Name, gracePeriod)
+		if err := c.markInstanceMissing(node, time.Now()); err != nil {
+			return false, err
+		}
+		c.recordNodeEvent(node, corev1.EventTypeNormal, reasonGracePeriodStarted,
+			"Instance %s is missing, node will be deleted if it is still missing after %s", node.Spec.ProviderID, gracePeriod)
+		c.queue.AddAfter(node.Name, gracePeriod)
+		return false, nil
+	}
+	if remaining := gracePeriod - time.Since(since); remaining > 0 {
+		klog.Infof("instance of node %s missing since %s, %s left in grace period", node.Name, since.Format(time.RFC3339), remaining.Round(time.Second))
+		c.queue.AddAfter(node.Name, remaining)
+		return false, nil
+	}
+	return true, nil
+}
+
+// markInstanceMissing annotate the node with the time its instance was first seen missing
+func (c *Controller) markInstanceMissing(node *corev1.Node, since time.Time) error {
+	return c.patchAnnotations(node.Name, map[string]interface{}{
+		annotationInstanceMissingSince: since.UTC().Format(time.RFC3339),
+	})
+}
+
+// clearInstanceMissing remove the missing mark once the instance is seen again
+func (c *Controller) clearInstanceMissing(node *corev1.Node) error {
+	if _, ok := node.Annotations[annotationInstanceMissingSince]; !ok {
+		return nil
+	}
+	if config.Current().DryRun {
+		klog.Infof("[dry-run] instance of node %s is back, would clear the missing mark", node.Name)
+		return nil
+	}
+	klog.Infof("instance of node %s is back, clear the missing mark", node.Name)
+	if err := c.patchAnnotations(node.Name, map[string]interface{}{
+		annotationInstanceMissingSince: nil,
+	}); err != nil {
+		return err
+	}
+	c.recordNodeEvent(node, corev1.EventTypeNormal, reasonGracePeriodCancelled, "Instance %s is back, deletion cancelled", node.Spec.ProviderID)
+	return nil
+}
+
+// patchAnnotations merge patch node annotations, a nil value removes the annotation
+func (c *Controller) patchAnnotations(nodeName string, annotations map[string]interface{}) error {
+	return c.patchNode(nodeName, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+}
+
+// patchNode merge patch a node
+func (c *Controller) patchNode(nodeName string, patch map[string]interface{}) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = c.clientset.CoreV1().Nodes().Patch(c.ctx, nodeName, types.MergePatchType, data, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/option"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+	"time"
+)
+
+// fixedStatusAPI cloud provider reporting the same status for every instance
+type fixedStatusAPI struct {
+	status types.InstanceStatus
+}
+
+func (f *fixedStatusAPI) GetInstanceStatus(_ context.Context, node *corev1.Node) (*types.InstanceStatus, error) {
+	status := f.status
+	status.ProviderID = node.Spec.ProviderID
+	return &status, nil
+}
+
+func TestGracePeriodMarksAndRequeues(t *testing.T) {
+	gracePeriod := 10 * time.Minute
+	c, clientset, queue := newTestController(t, &option.Options{DeletionGracePeriod: gracePeriod}, notReadyNode("node-1", nil))
+
+	node, _ := c.nodeLister.Get("node-1")
+	expired, err := c.gracePeriodExpired(node)
+	if err != nil || expired {
+		t.Fatalf("expected the first detection to start the grace period, got expired=%v err=%v", expired, err)
+	}
+	node = getNode(t, clientset, "node-1")
+	since, marked := missingSince(node)
+	if !marked || time.Since(since) > time.Minute {
+		t.Fatalf("expected the node to be marked missing now, got %v %v", since, marked)
+	}
+	if after, ok := queue.requeuedAfter("node-1"); !ok || after != gracePeriod {
+		t.Errorf("expected the node to be checked again after the grace period, got %s %v", after, ok)
+	}
+
+	node.Annotations[annotationInstanceMissingSince] = time.Now().Add(-4 * time.Minute).UTC().Format(time.RFC3339)
+	expired, err = c.gracePeriodExpired(node)
+	if err != nil || expired {
+		t.Fatalf("expected the grace period to go on, got expired=%v err=%v", expired, err)
+	}
+	if after, _ := queue.requeuedAfter("node-1"); after <= 5*time.Minute || after > 6*time.Minute {
+		t.Errorf("expected the node to be checked again once the grace period is over, got %s", after)
+	}
+
+	node.Annotations[annotationInstanceMissingSince] = time.Now().Add(-gracePeriod).UTC().Format(time.RFC3339)
+	if expired, err = c.gracePeriodExpired(node); err != nil || !expired {
+		t.Errorf("expected the grace period to be over, got expired=%v err=%v", expired, err)
+	}
+}
+
+func TestGracePeriodDisabled(t *testing.T) {
+	c, clientset, _ := newTestController(t, &option.Options{}, notReadyNode("node-1", nil))
+
+	node, _ := c.nodeLister.Get("node-1")
+	if expired, err := c.gracePeriodExpired(node); err != nil || !expired {
+		t.Fatalf("expected no grace period, got expired=%v err=%v", expired, err)
+	}
+	if _, marked := missingSince(getNode(t, clientset, "node-1")); marked {
+		t.Errorf("expected the node not to be marked without grace period")
+	}
+}
+
+func TestMissingMarkClearedOnlyWhenInstanceExists(t *testing.T) {
+	marked := map[string]string{
+		annotationInstanceMissingSince: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+		annotationDrainStartedAt:       time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+	}
+	cases := []struct {
+		state   types.InstanceState
+		cleared bool
+	}{
+		{types.InstanceUnknown, false},
+		{types.InstanceRunning, true},
+		{types.InstanceStopped, true},
+	}
+	for _, tc := range cases {
+		o := &option.Options{DeletionGracePeriod: time.Hour, BatchSize: 1}
+		c, clientset, _ := newTestController(t, o, notReadyNode("node-1", marked))
+		c.instances = newInstanceBatcher(context.Background(), &fixedStatusAPI{status: types.InstanceStatus{State: tc.state}}, 0, 1, time.Minute)
+
+		node, _ := c.nodeLister.Get("node-1")
+		if err := c.processNode(node); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.state, err)
+		}
+		node = getNode(t, clientset, "node-1")
+		_, missing := missingSince(node)
+		_, draining := drainStartedAt(node)
+		if missing == tc.cleared || draining == tc.cleared {
+			t.Errorf("%s: expected the missing mark and the drain cleared %v, got missing=%v draining=%v", tc.state, tc.cleared, missing, draining)
+		}
+	}
+}
+
+func TestDryRunNeverPatchesTheNode(t *testing.T) {
+	gracePeriod := 10 * time.Minute
+	marked := map[string]string{
+		annotationDrainStartedAt: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+		annotationDrainCordoned:  "true",
+	}
+	c, clientset, queue := newTestController(t, &option.Options{DryRun: true, DeletionGracePeriod: gracePeriod}, notReadyNode("node-1", marked))
+
+	node, _ := c.nodeLister.Get("node-1")
+	if expired, err := c.gracePeriodExpired(node); err != nil || expired {
+		t.Fatalf("expected the first detection to start the grace period, got expired=%v err=%v", expired, err)
+	}
+	if _, marked := missingSince(getNode(t, clientset, "node-1")); marked {
+		t.Errorf("expected a dry run not to annotate the node")
+	}
+	if _, ok := dryRun.missingSince("node-1"); !ok {
+		t.Errorf("expected a dry run to keep the missing time in memory")
+	}
+	if after, ok := queue.requeuedAfter("node-1"); !ok || after != gracePeriod {
+		t.Errorf("expected the node to be checked again after the grace period, got %s %v", after, ok)
+	}
+
+	dryRun.markMissing("node-1", time.Now().Add(-gracePeriod))
+	if expired, err := c.gracePeriodExpired(node); err != nil || !expired {
+		t.Errorf("expected the grace period kept in memory to be over, got expired=%v err=%v", expired, err)
+	}
+
+	if err := c.cancelDrain(node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, draining := drainStartedAt(getNode(t, clientset, "node-1")); !draining {
+		t.Errorf("expected a dry run not to cancel the drain of the node")
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("expected a dry run never to patch the node, got %v", action)
+		}
+	}
+}
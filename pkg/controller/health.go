@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/client"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queueStallTimeout the workqueue is wedged when it holds nodes and no worker took one for this long
+const queueStallTimeout = 10 * time.Minute
+
+// HealthCheck result of one liveness or readiness check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport result of the liveness or readiness checks, healthy when every check is ok
+type HealthReport struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+func newHealthReport(checks ...HealthCheck) HealthReport {
+	report := HealthReport{Healthy: true, Checks: checks}
+	for _, check := range checks {
+		report.Healthy = report.Healthy && check.OK
+	}
+	return report
+}
+
+// LeaderStatus holder of the leader lease as last observed by this process
+type LeaderStatus struct {
+	Identity   string     `json:"identity"`
+	Leader     string     `json:"leader,omitempty"`
+	IsLeader   bool       `json:"isLeader"`
+	ObservedAt *time.Time `json:"observedAt,omitempty"`
+}
+
+// runtimeHealth state of the running controller read by the liveness and readiness checks,
+// the controller only runs on the leader
+type runtimeHealth struct {
+	mu sync.Mutex
+
+	identity   string
+	leader     string
+	observedAt time.Time
+
+	providersReady bool
+	providersError string // why the cloud providers failed to initialize, retried meanwhile
+
+	started         bool
+	hasSynced       func() bool
+	queueLen        func() int
+	expectedWorkers int
+	workers         int
+	lastTaken       time.Time // last time a worker took a node from the queue or started
+
+	now func() time.Time
+}
+
+var health = &runtimeHealth{now: time.Now}
+
+// controllerStarted register the informer, the queue and the number of workers of the controller that started on the leader
+func (h *runtimeHealth) controllerStarted(hasSynced func() bool, queueLen func() int, workers int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started = true
+	h.hasSynced = hasSynced
+	h.queueLen = queueLen
+	h.expectedWorkers = workers
+	h.lastTaken = h.now()
+}
+
+// workerStarted count a running worker goroutine, the returned func is called when it exits.
+// The workers start once the cache is synced, the queue filled meanwhile is not stalled
+func (h *runtimeHealth) workerStarted() func() {
+	h.mu.Lock()
+	h.workers++
+	h.lastTaken = h.now()
+	h.mu.Unlock()
+	return func() {
+		h.mu.Lock()
+		h.workers--
+		h.mu.Unlock()
+	}
+}
+
+// taken record a worker took a node from the queue
+func (h *runtimeHealth) taken() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastTaken = h.now()
+}
+
+// providersInitialized record the result of the initialization of the cloud providers
+func (h *runtimeHealth) providersInitialized(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.providersReady = err == nil
+	h.providersError = ""
+	if err != nil {
+		h.providersError = err.Error()
+	}
+}
+
+func (h *runtimeHealth) setIdentity(identity string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.identity = identity
+}
+
+// newLeader record the identity of the new lease holder
+func (h *runtimeHealth) newLeader(leader string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leader = leader
+	h.observedAt = h.now()
+}
+
+// liveness the workers are running and the queue is not wedged, a process that is not the leader is alive
+// and so is a controller syncing its informer cache, its workers only start once the cache is synced
+func (h *runtimeHealth) liveness() HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.started {
+		return newHealthReport(HealthCheck{Name: "workers", OK: true, Message: "controller not started, not the leader"})
+	}
+	if !h.hasSynced() {
+		return newHealthReport(HealthCheck{Name: "workers", OK: true, Message: "informer cache syncing, workers not started yet"})
+	}
+	workers := HealthCheck{Name: "workers", OK: h.workers >= h.expectedWorkers,
+		Message: fmt.Sprintf("%d of %d workers running", h.workers, h.expectedWorkers)}
+	queue := HealthCheck{Name: "workqueue", OK: true}
+	depth := h.queueLen()
+	if stalled := h.now().Sub(h.lastTaken); depth > 0 && stalled > queueStallTimeout {
+		queue.OK = false
+		queue.Message = fmt.Sprintf("%d nodes queued and none taken for %s", depth, stalled.Round(time.Second))
+	} else {
+		queue.Message = fmt.Sprintf("%d nodes queued", depth)
+	}
+	return newHealthReport(workers, queue)
+}
+
+// readiness the kubernetes client and the cloud providers are set up, their credentials are accepted,
+// and the informer cache is synced on the leader. The credentials are checked when the providers are initialized,
+// the auth failures of the later calls are reported until a call succeeds again
+func (h *runtimeHealth) readiness() HealthReport {
+	kube := HealthCheck{Name: "kubernetes", OK: client.Client != nil}
+	if !kube.OK {
+		kube.Message = "kubernetes client not configured"
+	}
+
+	h.mu.Lock()
+	cloud := HealthCheck{Name: "cloudProviders", OK: h.providersReady}
+	providersError := h.providersError
+	h.mu.Unlock()
+	if !cloud.OK {
+		cloud.Message = "cloud providers not initialized"
+		if providersError != "" {
+			cloud.Message += ": " + providersError
+		}
+	} else {
+		var rejected []string
+		for provider, status := range cloudHealth.status() {
+			if status.AuthFailure {
+				rejected = append(rejected, fmt.Sprintf("%s: %s", provider, status.AuthError))
+			}
+		}
+		if len(rejected) > 0 {
+			sort.Strings(rejected)
+			cloud.OK = false
+			cloud.Message = "credentials rejected by " + strings.Join(rejected, "; ")
+		} else {
+			cloud.Message = "credentials checked at startup, none rejected since"
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	informer := HealthCheck{Name: "informer", OK: true}
+	switch {
+	case !h.started:
+		informer.Message = "controller not started, not the leader"
+	case !h.hasSynced():
+		informer.OK = false
+		informer.Message = "node informer cache not synced"
+	}
+	return newHealthReport(kube, cloud, informer)
+}
+
+func (h *runtimeHealth) leaderStatus() LeaderStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := LeaderStatus{Identity: h.identity, Leader: h.leader, IsLeader: h.leader != "" && h.leader == h.identity}
+	if !h.observedAt.IsZero() {
+		observedAt := h.observedAt
+		status.ObservedAt = &observedAt
+	}
+	return status
+}
+
+// Liveness check the workers of the controller are running and its workqueue is not wedged
+func Liveness() HealthReport {
+	return health.liveness()
+}
+
+// Readiness check the controller is configured, its cloud credentials are accepted and its informer is synced
+func Readiness() HealthReport {
+	return health.readiness()
+}
+
+// CloudProvidersInitialized record the result of the initialization of the cloud providers, reported by the readiness
+func CloudProvidersInitialized(err error) {
+	health.providersInitialized(err)
+}
+
+// SetIdentity set the leader election identity of this process
+func SetIdentity(identity string) {
+	health.setIdentity(identity)
+}
+
+// ObserveLeader record the new holder of the leader lease
+func ObserveLeader(leader string) {
+	health.newLeader(leader)
+}
+
+// GetLeaderStatus return the holder of the leader lease
+func GetLeaderStatus() LeaderStatus {
+	return health.leaderStatus()
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func checkOK(report HealthReport, name string) bool {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check.OK
+		}
+	}
+	return false
+}
+
+func TestLivenessWedgedQueue(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := &runtimeHealth{now: func() time.Time { return now }}
+	if report := h.liveness(); !report.Healthy {
+		t.Errorf("expected a process that is not the leader to be alive, got %+v", report)
+	}
+
+	depth := 3
+	synced := false
+	h.controllerStarted(func() bool { return synced }, func() int { return depth }, 2)
+	now = now.Add(queueStallTimeout + time.Second)
+	if report := h.liveness(); !report.Healthy {
+		t.Errorf("expected the controller to be alive while its cache syncs, got %+v", report)
+	}
+	synced = true
+	h.workerStarted()
+	if report := h.liveness(); report.Healthy || checkOK(report, "workers") {
+		t.Errorf("expected a missing worker to fail the liveness, got %+v", report)
+	}
+	h.workerStarted()
+	if report := h.liveness(); !report.Healthy {
+		t.Errorf("expected the controller to be alive, got %+v", report)
+	}
+
+	now = now.Add(queueStallTimeout + time.Second)
+	if report := h.liveness(); report.Healthy || checkOK(report, "workqueue") {
+		t.Errorf("expected a queue without progress to be wedged, got %+v", report)
+	}
+	depth = 0
+	if report := h.liveness(); !report.Healthy {
+		t.Errorf("expected an empty queue not to be wedged, got %+v", report)
+	}
+	depth = 3
+	h.taken()
+	if report := h.liveness(); !report.Healthy {
+		t.Errorf("expected a queue with progress not to be wedged, got %+v", report)
+	}
+}
+
+func TestReadinessInformerSync(t *testing.T) {
+	h := &runtimeHealth{now: time.Now}
+	if report := h.readiness(); !checkOK(report, "informer") {
+		t.Errorf("expected the informer check to pass when not the leader, got %+v", report)
+	}
+	synced := false
+	h.controllerStarted(func() bool { return synced }, func() int { return 0 }, 1)
+	if report := h.readiness(); checkOK(report, "informer") || report.Healthy {
+		t.Errorf("expected the unsynced informer to fail the readiness, got %+v", report)
+	}
+	synced = true
+	if report := h.readiness(); !checkOK(report, "informer") {
+		t.Errorf("expected the synced informer to pass, got %+v", report)
+	}
+}
+
+func TestReadinessCloudProvidersInitialized(t *testing.T) {
+	h := &runtimeHealth{now: time.Now}
+	if report := h.readiness(); checkOK(report, "cloudProviders") {
+		t.Errorf("expected the readiness to fail before the providers are initialized, got %+v", report)
+	}
+	h.providersInitialized(fmt.Errorf("credentials rejected"))
+	if report := h.readiness(); checkOK(report, "cloudProviders") {
+		t.Errorf("expected a failed initialization to fail the readiness, got %+v", report)
+	}
+	h.providersInitialized(nil)
+	if report := h.readiness(); !checkOK(report, "cloudProviders") {
+		t.Errorf("expected the initialized providers to pass, got %+v", report)
+	}
+}
+
+func TestLeaderStatus(t *testing.T) {
+	h := &runtimeHealth{now: time.Now}
+	h.setIdentity("pod-a")
+	if status := h.leaderStatus(); status.IsLeader || status.ObservedAt != nil {
+		t.Errorf("expected no leader observed yet, got %+v", status)
+	}
+	h.newLeader("pod-b")
+	if status := h.leaderStatus(); status.IsLeader || status.Leader != "pod-b" || status.ObservedAt == nil {
+		t.Errorf("expected pod-b to be the leader, got %+v", status)
+	}
+	h.newLeader("pod-a")
+	if status := h.leaderStatus(); !status.IsLeader {
+		t.Errorf("expected this process to be the leader, got %+v", status)
+	}
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"path"
+	"sync/atomic"
+)
+
+// annotationSkip opts a node out of the controller when set to "true"
+const annotationSkip = "node-lifecycle.io/skip"
+
+// labelControlPlane role label of the control-plane nodes
+const labelControlPlane = "node-role.kubernetes.io/control-plane"
+
+// excludeSelector exclude node selector parsed once for the options it was read from,
+// a reload replaces the options as a whole so the selector is parsed again only when they change
+type excludeSelector struct {
+	options  *option.Options
+	selector labels.Selector // nil when no selector is set
+}
+
+var excludeSelectors atomic.Pointer[excludeSelector]
+
+// parsedExcludeSelector return the parsed exclude node selector of the options, nil if none is set
+func parsedExcludeSelector(o *option.Options) labels.Selector {
+	if cached := excludeSelectors.Load(); cached != nil && cached.options == o {
+		return cached.selector
+	}
+	parsed := &excludeSelector{options: o}
+	if o.ExcludeNodeSelector != "" {
+		selector, err := labels.Parse(o.ExcludeNodeSelector)
+		if err != nil {
+			// validated with the options, can't happen
+			klog.Errorf("invalid exclude node selector %q: %v", o.ExcludeNodeSelector, err)
+		} else {
+			parsed.selector = selector
+		}
+	}
+	excludeSelectors.Store(parsed)
+	return parsed.selector
+}
+
+// nodesInScope count the nodes the controller manages, the base of the deletion percentage
+func nodesInScope(nodes []*corev1.Node) int {
+	count := 0
+	for _, node := range nodes {
+		if excluded, _ := nodeExcluded(node); !excluded {
+			count++
+		}
+	}
+	return count
+}
+
+// nodeExcluded whether the controller ignores the node, and why: the skip annotation, a control-plane node,
+// a node matching the exclude selector or an exclude name pattern. The node selector is applied by the informer
+func nodeExcluded(node *corev1.Node) (bool, string) {
+	if node.Annotations[annotationSkip] == "true" {
+		return true, "annotated " + annotationSkip
+	}
+	o := config.Current()
+	if _, ok := node.Labels[labelControlPlane]; ok && !o.IncludeControlPlane {
+		return true, "control-plane node"
+	}
+	for _, pattern := range o.ExcludeNodeNames {
+		// validated with the options, a bad pattern never matches
+		if matched, _ := path.Match(pattern, node.Name); matched {
+			return true, "name matches the exclude pattern " + pattern
+		}
+	}
+	if selector := parsedExcludeSelector(o); selector != nil && selector.Matches(labels.Set(node.Labels)) {
+		return true, "matches the exclude node selector"
+	}
+	return false, ""
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestNodeExcluded(t *testing.T) {
+	controlPlane := map[string]string{labelControlPlane: ""}
+	cases := []struct {
+		name        string
+		options     option.Options
+		labels      map[string]string
+		annotations map[string]string
+		excluded    bool
+	}{
+		{name: "worker", labels: map[string]string{"pool": "spot"}},
+		{name: "skip annotation", annotations: map[string]string{annotationSkip: "true"}, excluded: true},
+		{name: "skip annotation false", annotations: map[string]string{annotationSkip: "false"}},
+		{name: "control-plane", labels: controlPlane, excluded: true},
+		{name: "control-plane included", options: option.Options{IncludeControlPlane: true}, labels: controlPlane},
+		{name: "exclude selector", options: option.Options{ExcludeNodeSelector: "pool in (system,gpu)"}, labels: map[string]string{"pool": "gpu"}, excluded: true},
+		{name: "exclude selector no match", options: option.Options{ExcludeNodeSelector: "pool in (system,gpu)"}, labels: map[string]string{"pool": "spot"}},
+		{name: "exclude name", options: option.Options{ExcludeNodeNames: []string{"infra-*", "no?e"}}, excluded: true},
+		{name: "exclude name no match", options: option.Options{ExcludeNodeNames: []string{"infra-*"}}},
+	}
+	for _, tc := range cases {
+		config.Options = &tc.options
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: tc.labels, Annotations: tc.annotations}}
+		if excluded, reason := nodeExcluded(node); excluded != tc.excluded {
+			t.Errorf("%s: expected excluded %v, got %v (%s)", tc.name, tc.excluded, excluded, reason)
+		}
+	}
+}
+
+func TestExcludeSelectorParsedOnce(t *testing.T) {
+	o := &option.Options{ExcludeNodeSelector: "pool=system"}
+	if selector := parsedExcludeSelector(o); selector == nil || selector.String() != "pool=system" {
+		t.Fatalf("expected the exclude selector, got %v", selector)
+	}
+	cached := excludeSelectors.Load()
+	parsedExcludeSelector(o)
+	if excludeSelectors.Load() != cached {
+		t.Errorf("expected the selector to be parsed once for the same options")
+	}
+
+	reloaded := &option.Options{ExcludeNodeSelector: "pool=gpu"}
+	if selector := parsedExcludeSelector(reloaded); selector == nil || selector.String() != "pool=gpu" {
+		t.Errorf("expected the reloaded selector, got %v", selector)
+	}
+	if selector := parsedExcludeSelector(&option.Options{}); selector != nil {
+		t.Errorf("expected no selector when none is set, got %v", selector)
+	}
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s.io/klog/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pauseStateKey key of the paused deletions in the state ConfigMap of the controller
+const pauseStateKey = "pausedDeletions"
+
+// Pause deletions paused by an operator
+type Pause struct {
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// PauseState deletions paused for every provider or for some providers, persisted so a new leader honors them
+type PauseState struct {
+	All       *Pause           `json:"all,omitempty"`
+	Providers map[string]Pause `json:"providers,omitempty"`
+}
+
+// pauseStore paused deletions, loaded from and saved to the state ConfigMap by the leader
+type pauseStore struct {
+	mu     sync.Mutex
+	state  PauseState
+	loaded bool
+}
+
+var pauses = &pauseStore{}
+
+// paused whether the deletions of the provider are paused, and why. Deletions stay paused until the state is loaded
+func (p *pauseStore) paused(provider string) (bool, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.loaded {
+		return true, "paused deletions not loaded yet"
+	}
+	if pause := p.state.All; pause != nil {
+		return true, describePause("all providers", *pause)
+	}
+	if pause, ok := p.state.Providers[provider]; ok {
+		return true, describePause("provider "+provider, pause)
+	}
+	return false, ""
+}
+
+func describePause(scope string, pause Pause) string {
+	message := fmt.Sprintf("deletions paused for %s since %s", scope, pause.Since.Format(time.RFC3339))
+	if pause.Reason != "" {
+		message += ": " + pause.Reason
+	}
+	return message
+}
+
+// unload hold the deletions until the paused deletions are restored
+func (p *pauseStore) unload() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loaded = false
+}
+
+// restore set the paused deletions read from the state ConfigMap
+func (p *pauseStore) restore(data string) error {
+	var state PauseState
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return fmt.Errorf("decode %s: %w", pauseStateKey, err)
+		}
+	}
+	p.mu.Lock()
+	p.state, p.loaded = state, true
+	p.mu.Unlock()
+	if state.All != nil || len(state.Providers) > 0 {
+		klog.Warningf("deletions are paused: %s", state.summary())
+	}
+	return nil
+}
+
+// update apply a change to the paused deletions and persist them, the change is dropped if it can't be saved
+func (p *pauseStore) update(ctx context.Context, change func(state *PauseState)) (PauseState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.loaded {
+		return p.state, fmt.Errorf("paused deletions not loaded yet")
+	}
+	next := p.state.clone()
+	change(&next)
+	data, err := json.Marshal(next)
+	if err != nil {
+		return p.state, err
+	}
+	if err := controllerState.save(ctx, pauseStateKey, string(data)); err != nil {
+		return p.state, err
+	}
+	p.state = next
+	return next, nil
+}
+
+func (p *pauseStore) get() (PauseState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state.clone(), p.loaded
+}
+
+func (s PauseState) clone() PauseState {
+	next := PauseState{}
+	if s.All != nil {
+		all := *s.All
+		next.All = &all
+	}
+	if len(s.Providers) > 0 {
+		next.Providers = make(map[string]Pause, len(s.Providers))
+		for provider, pause := range s.Providers {
+			next.Providers[provider] = pause
+		}
+	}
+	return next
+}
+
+// summary the paused scopes, for the logs
+func (s PauseState) summary() string {
+	if s.All != nil {
+		return "all providers"
+	}
+	var providers []string
+	for provider := range s.Providers {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	return "providers " + strings.Join(providers, ", ")
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPausedDeletions(t *testing.T) {
+	p := &pauseStore{}
+	if paused, _ := p.paused("aws"); !paused {
+		t.Errorf("expected deletions to stay paused until the state is loaded")
+	}
+
+	p.loaded = true
+	if paused, reason := p.paused("aws"); paused {
+		t.Errorf("expected deletions to run, got paused: %s", reason)
+	}
+
+	p.state = PauseState{Providers: map[string]Pause{"azure": {Reason: "outage", Since: time.Now()}}}
+	if paused, reason := p.paused("azure"); !paused || !strings.Contains(reason, "outage") {
+		t.Errorf("expected azure deletions to be paused with the reason, got %v %q", paused, reason)
+	}
+	if paused, _ := p.paused("aws"); paused {
+		t.Errorf("expected aws deletions to run while only azure is paused")
+	}
+
+	p.state.All = &Pause{Since: time.Now()}
+	if paused, _ := p.paused("aws"); !paused {
+		t.Errorf("expected every provider to be paused")
+	}
+
+	state, _ := p.get()
+	state.Providers["aws"] = Pause{}
+	if _, ok := p.state.Providers["aws"]; ok {
+		t.Errorf("expected get to return a copy of the state")
+	}
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"k8s.io/klog/v2"
+	"sync"
+	"time"
+)
+
+// backoff of a throttled provider without Retry-After, doubled on every throttling until the max
+const (
+	throttleBaseDelay = 5 * time.Second
+	throttleMaxDelay  = 5 * time.Minute
+)
+
+// ProviderStatus health of the calls to a cloud provider
+type ProviderStatus struct {
+	ThrottledUntil   *time.Time `json:"throttledUntil,omitempty"`
+	AuthFailure      bool       `json:"authFailure"`
+	AuthFailureSince *time.Time `json:"authFailureSince,omitempty"`
+	AuthError        string     `json:"authError,omitempty"`
+}
+
+// providerHealth throttling backoff and auth failure alert of each cloud provider, shared by all the workers
+// so a throttled API is paused for every node and not only for the node whose call was throttled
+type providerHealth struct {
+	mu        sync.Mutex
+	providers map[string]*providerState
+	now       func() time.Time
+}
+
+type providerState struct {
+	throttles        int
+	throttledUntil   time.Time
+	authFailureSince time.Time
+	authError        string
+}
+
+var cloudHealth = newProviderHealth()
+
+func newProviderHealth() *providerHealth {
+	return &providerHealth{providers: map[string]*providerState{}, now: time.Now}
+}
+
+// state the state of a provider, the caller holds the lock
+func (h *providerHealth) state(provider string) *providerState {
+	state, ok := h.providers[provider]
+	if !ok {
+		state = &providerState{}
+		h.providers[provider] = state
+	}
+	return state
+}
+
+// backoff how long the calls to the provider stay paused, 0 if they are not
+func (h *providerHealth) backoff(provider string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.providers[provider]
+	if !ok {
+		return 0
+	}
+	if wait := state.throttledUntil.Sub(h.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// throttled pause the calls to the provider for the delay requested by the API, or an exponential delay if it didn't tell,
+// and return the delay
+func (h *providerHealth) throttled(provider string, retryAfter time.Duration) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := h.state(provider)
+	now := h.now()
+	if now.Before(state.throttledUntil) {
+		// another worker already paused the provider
+		return state.throttledUntil.Sub(now)
+	}
+	delay := retryAfter
+	if delay <= 0 {
+		delay = throttleBaseDelay << min(state.throttles, 6)
+		if delay > throttleMaxDelay {
+			delay = throttleMaxDelay
+		}
+	}
+	state.throttles++
+	state.throttledUntil = now.Add(delay)
+	klog.Warningf("cloud provider %s is throttled, pause its calls for %s", provider, delay)
+	return delay
+}
+
+// authFailed raise the auth failure alert of the provider, it returns true if the alert was just raised
+func (h *providerHealth) authFailed(provider string, err error) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := h.state(provider)
+	state.authError = err.Error()
+	if !state.authFailureSince.IsZero() {
+		return false
+	}
+	state.authFailureSince = h.now()
+	klog.Errorf("cloud provider %s rejected the credentials of the controller: %v", provider, err)
+	return true
+}
+
+// succeeded reset the throttling backoff and clear the auth failure alert of the provider after a successful call,
+// it returns true if an alert was cleared
+func (h *providerHealth) succeeded(provider string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.providers[provider]
+	if !ok {
+		return false
+	}
+	state.throttles = 0
+	if state.authFailureSince.IsZero() {
+		return false
+	}
+	klog.Infof("cloud provider %s accepts the credentials of the controller again", provider)
+	state.authFailureSince = time.Time{}
+	state.authError = ""
+	return true
+}
+
+func (h *providerHealth) status() map[string]ProviderStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	statuses := map[string]ProviderStatus{}
+	for provider, state := range h.providers {
+		status := ProviderStatus{AuthFailure: !state.authFailureSince.IsZero(), AuthError: state.authError}
+		if now.Before(state.throttledUntil) {
+			until := state.throttledUntil
+			status.ThrottledUntil = &until
+		}
+		if status.AuthFailure {
+			since := state.authFailureSince
+			status.AuthFailureSince = &since
+		}
+		statuses[provider] = status
+	}
+	return statuses
+}
+
+// GetCloudProviderStatus return the throttling and auth failure state of the cloud providers
+func GetCloudProviderStatus() map[string]ProviderStatus {
+	return cloudHealth.status()
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestProviderHealth() (*providerHealth, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := newProviderHealth()
+	h.now = func() time.Time { return now }
+	return h, &now
+}
+
+func TestProviderHealthThrottleBackoff(t *testing.T) {
+	h, now := newTestProviderHealth()
+
+	if delay := h.throttled("aws", 0); delay != throttleBaseDelay {
+		t.Fatalf("expected first backoff %s, got %s", throttleBaseDelay, delay)
+	}
+	if wait := h.backoff("aws"); wait != throttleBaseDelay {
+		t.Errorf("expected aws paused for %s, got %s", throttleBaseDelay, wait)
+	}
+	if wait := h.backoff("azure"); wait != 0 {
+		t.Errorf("expected azure not paused, got %s", wait)
+	}
+	// a worker throttled during the pause doesn't extend it
+	if delay := h.throttled("aws", 0); delay != throttleBaseDelay {
+		t.Errorf("expected the current pause to be kept, got %s", delay)
+	}
+
+	*now = now.Add(throttleBaseDelay)
+	if delay := h.throttled("aws", 0); delay != 2*throttleBaseDelay {
+		t.Errorf("expected the backoff to double, got %s", delay)
+	}
+	*now = now.Add(time.Hour)
+	if delay := h.throttled("aws", 42*time.Second); delay != 42*time.Second {
+		t.Errorf("expected the delay requested by the API, got %s", delay)
+	}
+
+	*now = now.Add(time.Hour)
+	for i := 0; i < 20; i++ {
+		*now = now.Add(h.throttled("aws", 0))
+	}
+	if delay := h.throttled("aws", 0); delay != throttleMaxDelay {
+		t.Errorf("expected the backoff to be capped at %s, got %s", throttleMaxDelay, delay)
+	}
+
+	h.succeeded("aws")
+	*now = now.Add(throttleMaxDelay)
+	if delay := h.throttled("aws", 0); delay != throttleBaseDelay {
+		t.Errorf("expected a success to reset the backoff, got %s", delay)
+	}
+}
+
+func TestProviderHealthAuthFailure(t *testing.T) {
+	h, _ := newTestProviderHealth()
+
+	if !h.authFailed("tencent", errors.New("AuthFailure.SignatureExpire")) {
+		t.Fatalf("expected the first auth failure to raise the alert")
+	}
+	if h.authFailed("tencent", errors.New("AuthFailure.SignatureExpire")) {
+		t.Errorf("expected the alert to be raised once")
+	}
+	status := h.status()["tencent"]
+	if !status.AuthFailure || status.AuthFailureSince == nil || status.AuthError == "" {
+		t.Errorf("expected the status to report the auth failure, got %+v", status)
+	}
+
+	if !h.succeeded("tencent") {
+		t.Errorf("expected a success to clear the alert")
+	}
+	if h.succeeded("tencent") {
+		t.Errorf("expected the alert to be cleared once")
+	}
+	if h.status()["tencent"].AuthFailure {
+		t.Errorf("expected no auth failure after a success")
+	}
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/metrics"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"sync"
+	"time"
+)
+
+// ResyncStatus result of the periodic resyncs
+type ResyncStatus struct {
+	LastResync        *time.Time `json:"lastResync,omitempty"`
+	LastSuccess       *time.Time `json:"lastSuccess,omitempty"`
+	Succeeded         bool       `json:"succeeded"`
+	Error             string     `json:"error,omitempty"`
+	EnqueuedNodes     int        `json:"enqueuedNodes"`
+	ConsecutiveErrors int        `json:"consecutiveErrors"`
+}
+
+// resyncRecorder keeps the result of the last resync
+type resyncRecorder struct {
+	mu     sync.Mutex
+	status ResyncStatus
+}
+
+var resyncs = &resyncRecorder{}
+
+// record the result of a resync that enqueued nodes, err is the error that aborted it
+func (r *resyncRecorder) record(nodes int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.status.LastResync = &now
+	r.status.EnqueuedNodes = nodes
+	r.status.Succeeded = err == nil
+	if err != nil {
+		r.status.Error = err.Error()
+		r.status.ConsecutiveErrors++
+		return
+	}
+	r.status.Error = ""
+	r.status.ConsecutiveErrors = 0
+	r.status.LastSuccess = &now
+}
+
+func (r *resyncRecorder) get() ResyncStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// GetResyncStatus return the time and result of the last resync
+func GetResyncStatus() ResyncStatus {
+	return resyncs.get()
+}
+
+// resync enqueue every watched node from the informer cache, the workers process them with the rate limiting
+// of the queue and retry the failed ones, so a node failing never stops the resync of the others
+func (c *Controller) resync() {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("resync: list nodes error: %v", err)
+		resyncs.record(0, err)
+		return
+	}
+	c.prefetchInstances(nodes)
+	for _, node := range nodes {
+		c.queue.Add(node.Name)
+	}
+	klog.V(2).Infof("resync: enqueued %d nodes", len(nodes))
+	resyncs.record(len(nodes), nil)
+	metrics.LastResyncTimestamp.SetToCurrentTime()
+}
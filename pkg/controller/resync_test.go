@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"testing"
+	"time"
+)
+
+func TestResyncEnqueuesNodesFromLister(t *testing.T) {
+	config.Options = &option.Options{}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range []string{"node-1", "node-2"} {
+		if err := indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.NodeSpec{ProviderID: "aws:///a/" + name}}); err != nil {
+			t.Fatalf("add node: %v", err)
+		}
+	}
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]())
+	defer queue.ShutDown()
+	c := &Controller{
+		ctx:        context.Background(),
+		instances:  newInstanceBatcher(context.Background(), &fakeBatchAPI{}, 0, 100, time.Minute),
+		queue:      queue,
+		nodeLister: listerv1.NewNodeLister(indexer),
+	}
+
+	c.resync()
+	if queue.Len() != 2 {
+		t.Errorf("expected the 2 nodes to be enqueued, got %d", queue.Len())
+	}
+	status := GetResyncStatus()
+	if !status.Succeeded || status.EnqueuedNodes != 2 || status.LastSuccess == nil {
+		t.Errorf("unexpected resync status: %+v", status)
+	}
+}
@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s.io/klog/v2"
+	"sync"
+	"time"
+)
+
+// breakerStateKey key of the tripped circuit breaker in the state ConfigMap of the controller
+const breakerStateKey = "circuitBreaker"
+
+// CircuitBreakerStatus state of the mass-deletion circuit breaker
+type CircuitBreakerStatus struct {
+	Tripped          bool       `json:"tripped"`
+	TrippedAt        *time.Time `json:"trippedAt,omitempty"`
+	Reason           string     `json:"reason,omitempty"`
+	ResetAt          *time.Time `json:"resetAt,omitempty"`
+	RecentDeletions  int        `json:"recentDeletions"`
+	PendingDeletions int        `json:"pendingDeletions"`
+	MaxDeletions     int        `json:"maxDeletions"`
+	MaxPercentage    int        `json:"maxPercentage"`
+	PercentageMin    int        `json:"percentageMinNodes"`
+	Interval         string     `json:"interval"`
+}
+
+// circuitBreaker caps node deletions per time window and halts them all once a limit is exceeded,
+// until the reset timeout expires or it is reset manually. A node reserves its deletion before it is drained,
+// so the pods of a node are only disrupted once its deletion is guaranteed
+type circuitBreaker struct {
+	mu        sync.Mutex
+	deletions []time.Time
+	reserved  map[string]time.Time // nodes being drained or deleted, counted until they are deleted or released
+	tripped   bool
+	trippedAt time.Time
+	reason    string
+	unsaved   bool // tripped or reset since the last save
+	shadow    bool // counts the deletions of a dry run
+	now       func() time.Time
+
+	saveMu sync.Mutex // orders the saves, so an older state never overwrites a newer one
+}
+
+// breakerState tripped circuit breaker saved in the state ConfigMap, so a restart or a new leader keeps
+// the deletions halted
+type breakerState struct {
+	TrippedAt time.Time `json:"trippedAt"`
+	Reason    string    `json:"reason"`
+}
+
+var breaker = newCircuitBreaker()
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{reserved: map[string]time.Time{}, now: time.Now}
+}
+
+// reserve check whether the deletion of the node is allowed and hold it until the node is deleted or released,
+// it trips the breaker when the deletion would exceed a limit. A node that already holds a reservation keeps it,
+// even if the breaker tripped since. tripped is true only for the call that tripped the breaker
+func (b *circuitBreaker) reserve(node string, totalNodes int) (tripped bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	options := config.Current()
+	b.expire(now, options)
+	if _, ok := b.reserved[node]; ok {
+		return false, nil
+	}
+	if b.tripped {
+		return false, fmt.Errorf("node deletions halted since %s: %s", b.trippedAt.Format(time.RFC3339), b.reason)
+	}
+	if reason := b.exceeded(totalNodes, options); reason != "" {
+		b.trip(now, reason)
+		return true, fmt.Errorf("node deletions halted: %s", b.reason)
+	}
+	b.reserved[node] = now
+	return false, nil
+}
+
+// commit count the deletion of a reserved node in the current window
+func (b *circuitBreaker) commit(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.reserved[node]; ok {
+		delete(b.reserved, node)
+		b.deletions = append(b.deletions, b.now())
+	}
+}
+
+// release give back the reservation of a node that won't be deleted
+func (b *circuitBreaker) release(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.reserved, node)
+}
+
+// allow check whether one more deletion would be allowed, without counting nor tripping the breaker
+func (b *circuitBreaker) allow(totalNodes int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	options := config.Current()
+	b.expire(b.now(), options)
+	if b.tripped {
+		return fmt.Errorf("node deletions halted since %s: %s", b.trippedAt.Format(time.RFC3339), b.reason)
+	}
+	if reason := b.exceeded(totalNodes, options); reason != "" {
+		return fmt.Errorf("node deletions would be halted: %s", reason)
+	}
+	return nil
+}
+
+// exceeded return which limit one more deletion would exceed, empty if none
+func (b *circuitBreaker) exceeded(totalNodes int, options *option.Options) string {
+	next := len(b.deletions) + len(b.reserved) + 1
+	interval := options.DeletionInterval
+	if limit := options.MaxDeletionsPerInterval; limit > 0 && next > limit {
+		return fmt.Sprintf("deleting one more node would exceed %d deletions per %s", limit, interval)
+	}
+	// a percentage of a small cluster is a node or two, the count limit alone guards it
+	if totalNodes < options.PercentageMinNodes {
+		return ""
+	}
+	if limit := options.MaxDeletionPercentage; limit > 0 && totalNodes > 0 && next*100 > limit*totalNodes {
+		return fmt.Sprintf("deleting one more node would remove %d of %d nodes within %s, more than %d%%", next, totalNodes, interval, limit)
+	}
+	return ""
+}
+
+// reset close the breaker and forget the deletions of the current window, the nodes being drained keep their reservation
+func (b *circuitBreaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tripped {
+		klog.Infof("deletion circuit breaker reset, node deletions resumed")
+	}
+	b.tripped = false
+	b.reason = ""
+	b.deletions = nil
+}
+
+// resetAndSave reset the breaker once the reset is saved, it stays tripped if the save fails
+func (b *circuitBreaker) resetAndSave(ctx context.Context) error {
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
+	if err := controllerState.save(ctx, breakerStateKey, ""); err != nil {
+		return err
+	}
+	b.reset()
+	b.mu.Lock()
+	b.unsaved = false
+	b.mu.Unlock()
+	return nil
+}
+
+// restore set the tripped state read from the state ConfigMap
+func (b *circuitBreaker) restore(data string) error {
+	var state *breakerState
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return fmt.Errorf("decode %s: %w", breakerStateKey, err)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tripped, b.trippedAt, b.reason, b.unsaved = false, time.Time{}, "", false
+	if state != nil {
+		b.tripped, b.trippedAt, b.reason = true, state.TrippedAt, state.Reason
+		klog.Errorf("deletion circuit breaker tripped since %s, all node deletions are halted: %s", state.TrippedAt.Format(time.RFC3339), state.Reason)
+	}
+	return nil
+}
+
+// save persist the tripped state if it changed since the last save, a failed save is retried by the next call
+func (b *circuitBreaker) save(ctx context.Context) error {
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
+	b.mu.Lock()
+	if !b.unsaved {
+		b.mu.Unlock()
+		return nil
+	}
+	var state *breakerState
+	if b.tripped {
+		state = &breakerState{TrippedAt: b.trippedAt, Reason: b.reason}
+	}
+	b.unsaved = false
+	b.mu.Unlock()
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = controllerState.save(ctx, breakerStateKey, string(data))
+	}
+	if err != nil {
+		b.mu.Lock()
+		b.unsaved = true
+		b.mu.Unlock()
+	}
+	return err
+}
+
+func (b *circuitBreaker) status() CircuitBreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	options := config.Current()
+	b.expire(b.now(), options)
+	status := CircuitBreakerStatus{
+		Tripped:          b.tripped,
+		Reason:           b.reason,
+		RecentDeletions:  len(b.deletions),
+		PendingDeletions: len(b.reserved),
+		MaxDeletions:     options.MaxDeletionsPerInterval,
+		MaxPercentage:    options.MaxDeletionPercentage,
+		PercentageMin:    options.PercentageMinNodes,
+		Interval:         options.DeletionInterval.String(),
+	}
+	if b.tripped {
+		trippedAt := b.trippedAt
+		status.TrippedAt = &trippedAt
+		if timeout := options.CircuitBreakerResetTimeout; timeout > 0 {
+			resetAt := trippedAt.Add(timeout)
+			status.ResetAt = &resetAt
+		}
+	}
+	return status
+}
+
+func (b *circuitBreaker) trip(now time.Time, reason string) {
+	b.tripped = true
+	b.trippedAt = now
+	b.reason = reason
+	b.unsaved = true
+	if b.shadow {
+		klog.Warningf("[dry-run] deletion circuit breaker would trip and halt all node deletions: %s", reason)
+		return
+	}
+	klog.Errorf("deletion circuit breaker tripped, all node deletions are halted: %s", reason)
+}
+
+// expire drop deletions out of the window and close the breaker once the reset timeout is over
+func (b *circuitBreaker) expire(now time.Time, options *option.Options) {
+	if b.tripped {
+		if timeout := options.CircuitBreakerResetTimeout; timeout > 0 && now.Sub(b.trippedAt) >= timeout {
+			klog.Infof("deletion circuit breaker reset after %s, node deletions resumed", timeout)
+			b.tripped = false
+			b.reason = ""
+			b.deletions = nil
+		}
+	}
+	cutoff := now.Add(-options.DeletionInterval)
+	i := 0
+	for i < len(b.deletions) && !b.deletions[i].After(cutoff) {
+		i++
+	}
+	b.deletions = b.deletions[i:]
+}
+
+// GetCircuitBreakerStatus return the state of the mass-deletion circuit breaker
+func GetCircuitBreakerStatus() CircuitBreakerStatus {
+	return breaker.status()
+}
+
+// ResetCircuitBreaker manually resume node deletions after the circuit breaker tripped, the reset is saved
+// so a new leader doesn't halt them again
+func ResetCircuitBreaker(ctx context.Context) (CircuitBreakerStatus, error) {
+	if _, err := runningController(); err != nil {
+		return CircuitBreakerStatus{}, err
+	}
+	if err := breaker.resetAndSave(ctx); err != nil {
+		return breaker.status(), err
+	}
+	dryRun.breaker.reset()
+	klog.Infof("admin: circuit breaker reset")
+	return breaker.status(), nil
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(o *option.Options) (*circuitBreaker, *time.Time) {
+	config.Options = o
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker()
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+// reserveDeletion reserve and commit the deletion of a new node, the way a node without drain is deleted
+func reserveDeletion(b *circuitBreaker, totalNodes int) (bool, error) {
+	node := fmt.Sprintf("node-%d", len(b.deletions)+len(b.reserved))
+	tripped, err := b.reserve(node, totalNodes)
+	if err == nil {
+		b.commit(node)
+	}
+	return tripped, err
+}
+
+func TestCircuitBreakerTripsOnCount(t *testing.T) {
+	b, _ := newTestBreaker(&option.Options{MaxDeletionsPerInterval: 2, DeletionInterval: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if _, err := reserveDeletion(b, 100); err != nil {
+			t.Fatalf("deletion %d: unexpected error: %v", i, err)
+		}
+	}
+	tripped, err := reserveDeletion(b, 100)
+	if err == nil || !tripped {
+		t.Fatalf("expected the third deletion to trip the breaker, got tripped=%v err=%v", tripped, err)
+	}
+	tripped, err = reserveDeletion(b, 100)
+	if err == nil || tripped {
+		t.Fatalf("expected deletions to stay halted, got tripped=%v err=%v", tripped, err)
+	}
+	if !b.status().Tripped {
+		t.Errorf("expected status to report tripped")
+	}
+
+	b.reset()
+	if _, err := reserveDeletion(b, 100); err != nil {
+		t.Errorf("expected deletions to resume after reset, got %v", err)
+	}
+}
+
+func TestCircuitBreakerTripsOnPercentage(t *testing.T) {
+	b, _ := newTestBreaker(&option.Options{MaxDeletionPercentage: 50, DeletionInterval: time.Minute})
+
+	if _, err := reserveDeletion(b, 4); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := reserveDeletion(b, 4); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tripped, err := reserveDeletion(b, 4); err == nil || !tripped {
+		t.Fatalf("expected 3 of 4 nodes to trip the breaker, got tripped=%v err=%v", tripped, err)
+	}
+}
+
+func TestCircuitBreakerPercentageMinNodes(t *testing.T) {
+	b, _ := newTestBreaker(&option.Options{MaxDeletionsPerInterval: 2, MaxDeletionPercentage: 50, PercentageMinNodes: 10, DeletionInterval: time.Minute})
+
+	if _, err := reserveDeletion(b, 1); err != nil {
+		t.Fatalf("expected a single node cluster to delete its dead node, got %v", err)
+	}
+	if _, err := reserveDeletion(b, 2); err != nil {
+		t.Fatalf("expected a small cluster to be only limited by the count, got %v", err)
+	}
+	if tripped, err := reserveDeletion(b, 2); err == nil || !tripped {
+		t.Fatalf("expected the count limit to still trip the breaker, got tripped=%v err=%v", tripped, err)
+	}
+
+	b.reset()
+	if err := b.allow(10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.deletions = append(b.deletions, b.now(), b.now(), b.now(), b.now(), b.now())
+	config.Options.MaxDeletionsPerInterval = 0
+	if err := b.allow(10); err == nil {
+		t.Errorf("expected the percentage to apply from %d nodes", config.Options.PercentageMinNodes)
+	}
+}
+
+func TestCircuitBreakerWindowAndTimeout(t *testing.T) {
+	b, now := newTestBreaker(&option.Options{
+		MaxDeletionsPerInterval:    1,
+		DeletionInterval:           time.Minute,
+		CircuitBreakerResetTimeout: 10 * time.Minute,
+	})
+
+	if _, err := reserveDeletion(b, 100); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	*now = now.Add(2 * time.Minute)
+	if _, err := reserveDeletion(b, 100); err != nil {
+		t.Fatalf("expected the window to have expired, got %v", err)
+	}
+	if tripped, err := reserveDeletion(b, 100); err == nil || !tripped {
+		t.Fatalf("expected the breaker to trip, got tripped=%v err=%v", tripped, err)
+	}
+	*now = now.Add(5 * time.Minute)
+	if _, err := reserveDeletion(b, 100); err == nil {
+		t.Fatalf("expected deletions to stay halted before the reset timeout")
+	}
+	*now = now.Add(5 * time.Minute)
+	if _, err := reserveDeletion(b, 100); err != nil {
+		t.Errorf("expected the breaker to reset after the timeout, got %v", err)
+	}
+}
+
+func TestCircuitBreakerReservation(t *testing.T) {
+	b, _ := newTestBreaker(&option.Options{MaxDeletionsPerInterval: 1, DeletionInterval: time.Minute})
+
+	if _, err := b.reserve("a", 100); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.reserve("a", 100); err != nil {
+		t.Fatalf("expected a node to keep its reservation, got %v", err)
+	}
+	if tripped, err := b.reserve("b", 100); err == nil || !tripped {
+		t.Fatalf("expected a pending reservation to count against the limit, got tripped=%v err=%v", tripped, err)
+	}
+	if _, err := b.reserve("a", 100); err != nil {
+		t.Errorf("expected a reserved node to be deleted after the breaker tripped, got %v", err)
+	}
+
+	b.reset()
+	b.release("a")
+	if _, err := b.reserve("b", 100); err != nil {
+		t.Fatalf("expected a released reservation to be reusable, got %v", err)
+	}
+	b.commit("b")
+	if status := b.status(); status.RecentDeletions != 1 || status.PendingDeletions != 0 {
+		t.Errorf("expected the committed deletion to be counted in the window, got %+v", status)
+	}
+}
+
+func TestCircuitBreakerAllow(t *testing.T) {
+	b, _ := newTestBreaker(&option.Options{MaxDeletionsPerInterval: 1, DeletionInterval: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if err := b.allow(100); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := reserveDeletion(b, 100); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.allow(100); err == nil {
+		t.Fatalf("expected the limit to be reported once reached")
+	}
+	if b.status().Tripped {
+		t.Errorf("expected allow to never trip the breaker")
+	}
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sync"
+	"time"
+)
+
+// stateLoadInterval how often a failed load of the controller state is retried, deletions stay paused meanwhile
+const stateLoadInterval = 5 * time.Second
+
+// stateStore ConfigMap holding the state a new leader must honor: the paused deletions and the tripped circuit breaker.
+// Each state is saved under its own key
+type stateStore struct {
+	mu     sync.Mutex // serializes the writes of the ConfigMap
+	loaded bool
+
+	clientset       kubernetes.Interface
+	namespace, name string
+}
+
+var controllerState = &stateStore{}
+
+// loadControllerState read the paused deletions and the circuit breaker from the ConfigMap, retrying until it succeeds
+// or ctx is done. Deletions stay paused until both are loaded
+func loadControllerState(ctx context.Context, clientset kubernetes.Interface, namespace, name string) {
+	s := controllerState
+	s.mu.Lock()
+	s.clientset, s.namespace, s.name = clientset, namespace, name
+	s.loaded = false
+	s.mu.Unlock()
+	pauses.unload()
+	_ = wait.PollUntilContextCancel(ctx, stateLoadInterval, true, func(ctx context.Context) (bool, error) {
+		data, err := s.read(ctx)
+		if err == nil {
+			err = breaker.restore(data[breakerStateKey])
+		}
+		if err == nil {
+			err = pauses.restore(data[pauseStateKey])
+		}
+		if err != nil {
+			klog.Errorf("load controller state from configmap %s/%s error, deletions stay paused: %v", namespace, name, err)
+			return false, nil
+		}
+		s.mu.Lock()
+		s.loaded = true
+		s.mu.Unlock()
+		return true, nil
+	})
+}
+
+func (s *stateStore) read(ctx context.Context) (map[string]string, error) {
+	cm, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+// save write one key of the ConfigMap, creating it if needed. Nothing is written before the state is loaded,
+// it would overwrite the state of the previous leaders
+func (s *stateStore) save(ctx context.Context, key, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clientset == nil || !s.loaded {
+		return fmt.Errorf("controller state not loaded yet")
+	}
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+	cm, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+			Data:       map[string]string{key: data},
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	} else if err == nil {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = data
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("save %s in configmap %s/%s: %w", key, s.namespace, s.name, err)
+	}
+	return nil
+}
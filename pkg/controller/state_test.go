@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/option"
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControllerStateSurvivesLeaderChange(t *testing.T) {
+	o := &option.Options{MaxDeletionsPerInterval: 1, DeletionInterval: time.Hour}
+	saved := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "kube-system"},
+		Data: map[string]string{
+			pauseStateKey:   `{"providers":{"aws":{"reason":"outage","since":"2024-01-01T00:00:00Z"}}}`,
+			breakerStateKey: `{"trippedAt":"2024-01-01T00:00:00Z","reason":"too many deletions"}`,
+		},
+	}
+	_, clientset, _ := newTestController(t, o, saved)
+	ctx := context.Background()
+	configMap := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "state", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get configmap: %v", err)
+		}
+		return cm.Data
+	}
+
+	loadControllerState(ctx, clientset, "kube-system", "state")
+	if paused, reason := pauses.paused("aws"); !paused || !strings.Contains(reason, "outage") {
+		t.Errorf("expected the pause of the previous leader to be loaded, got %v %q", paused, reason)
+	}
+	if status := breaker.status(); !status.Tripped || status.Reason != "too many deletions" {
+		t.Errorf("expected the tripped breaker of the previous leader to be loaded, got %+v", status)
+	}
+
+	if err := breaker.resetAndSave(ctx); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if data := configMap()[breakerStateKey]; data != "" {
+		t.Errorf("expected the reset to be saved, got %q", data)
+	}
+	if _, err := reserveDeletion(breaker, 10); err != nil {
+		t.Fatalf("expected deletions to resume after the reset, got %v", err)
+	}
+	if tripped, _ := reserveDeletion(breaker, 10); !tripped {
+		t.Fatalf("expected the second deletion to trip the breaker")
+	}
+	if err := breaker.save(ctx); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if data := configMap()[breakerStateKey]; !strings.Contains(data, "deletions per") {
+		t.Errorf("expected the tripped breaker to be saved, got %q", data)
+	}
+	if data := configMap()[pauseStateKey]; !strings.Contains(data, "outage") {
+		t.Errorf("expected the pauses to be kept, got %q", data)
+	}
+
+	breaker = newCircuitBreaker()
+	loadControllerState(ctx, clientset, "kube-system", "state")
+	if !breaker.status().Tripped {
+		t.Errorf("expected a new leader to keep the deletions halted")
+	}
+}
+
+func TestControllerStateNotSavedBeforeLoad(t *testing.T) {
+	newTestController(t, &option.Options{})
+	breaker.trip(time.Now(), "test")
+	if err := breaker.save(context.Background()); err == nil {
+		t.Fatalf("expected the save to wait for the state of the previous leaders")
+	}
+	if err := breaker.resetAndSave(context.Background()); err == nil || !breaker.status().Tripped {
+		t.Errorf("expected the breaker to stay tripped when the reset can't be saved, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	cloudnodeutil "k8s.io/cloud-provider/node/helpers"
+	"k8s.io/klog/v2"
+)
+
+// shutdownTaint taint of nodes whose instance is stopped, same as the upstream cloud node lifecycle controller
+var shutdownTaint = &corev1.Taint{
+	Key:    cloudproviderapi.TaintNodeShutdown,
+	Effect: corev1.TaintEffectNoSchedule,
+}
+
+func hasShutdownTaint(node *corev1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.MatchTaint(shutdownTaint) {
+			return true
+		}
+	}
+	return false
+}
+
+// taintShutdownNode add the shutdown taint to a node whose instance is stopped
+func (c *Controller) taintShutdownNode(node *corev1.Node, providerState string) error {
+	if hasShutdownTaint(node) {
+		return nil
+	}
+	if config.Current().DryRun {
+		klog.Infof("[dry-run] instance of node %s is %s, would add taint %s", node.Name, providerState, shutdownTaint.Key)
+		return nil
+	}
+	klog.Infof("instance of node %s is %s, add taint %s", node.Name, providerState, shutdownTaint.Key)
+	if err := cloudnodeutil.AddOrUpdateTaintOnNode(c.clientset, node.Name, shutdownTaint); err != nil {
+		return err
+	}
+	c.recordNodeEvent(node, corev1.EventTypeNormal, reasonNodeShutdown, "Instance %s is %s, node tainted with %s", node.Spec.ProviderID, providerState, shutdownTaint.Key)
+	return nil
+}
+
+// untaintShutdownNode remove the shutdown taint once the instance is back and the node is ready
+func (c *Controller) untaintShutdownNode(node *corev1.Node) error {
+	if !hasShutdownTaint(node) {
+		return nil
+	}
+	if config.Current().DryRun {
+		klog.Infof("[dry-run] node %s is ready, would remove taint %s", node.Name, shutdownTaint.Key)
+		return nil
+	}
+	klog.Infof("node %s is ready, remove taint %s", node.Name, shutdownTaint.Key)
+	if err := cloudnodeutil.RemoveTaintOffNode(c.clientset, node.Name, node, shutdownTaint); err != nil {
+		return err
+	}
+	c.recordNodeEvent(node, corev1.EventTypeNormal, reasonNodeStarted, "Node is ready again, taint %s removed", shutdownTaint.Key)
+	return nil
+}
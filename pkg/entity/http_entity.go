@@ -21,3 +21,12 @@ func (resp *HTTPResponse) SuccessWithData(data interface{}) HTTPResponse {
 	resp.Message = "success"
 	return *resp
 }
+
+// FailWithData failure with data method
+func (resp *HTTPResponse) FailWithData(code int32, message string, data interface{}) HTTPResponse {
+	resp.Success = false
+	resp.Code = code
+	resp.Data = data
+	resp.Message = message
+	return *resp
+}
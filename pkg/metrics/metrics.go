@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+// namespace prefix of all the metrics
+const namespace = "cloud_node_lifecycle"
+
+// result label values of provider API calls
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	// NodeChecks nodes whose instance was looked up, by provider and instance state
+	NodeChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_checks_total",
+		Help:      "Number of not ready nodes whose instance was looked up, by provider and instance state.",
+	}, []string{"provider", "state"})
+
+	// NodeDeletions deleted nodes by provider and reason
+	NodeDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_deletions_total",
+		Help:      "Number of nodes deleted, by provider and reason.",
+	}, []string{"provider", "reason"})
+
+	// NodeSkips deletions skipped by policy, by provider and reason
+	NodeSkips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_skips_total",
+		Help:      "Number of node deletions skipped by policy (dry-run, grace period, circuit breaker...), by provider and reason.",
+	}, []string{"provider", "reason"})
+
+	// NodeErrors errors processing nodes, by provider and reason
+	NodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_errors_total",
+		Help:      "Number of errors while processing nodes, by provider and reason.",
+	}, []string{"provider", "reason"})
+
+	// ProviderAPIDuration latency of the cloud API calls
+	ProviderAPIDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_api_duration_seconds",
+		Help:      "Latency of the cloud provider API calls, by provider, operation and result.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"provider", "operation", "result"})
+
+	// ProviderErrors failed instance lookups by provider and error kind
+	ProviderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_errors_total",
+		Help:      "Number of failed instance lookups, by provider and error kind (NotFound, Throttled, AuthFailure, Transient, Permanent, Unknown).",
+	}, []string{"provider", "kind"})
+
+	// ProviderAuthFailure 1 while the provider rejects the credentials of the controller
+	ProviderAuthFailure = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "provider_auth_failure",
+		Help:      "1 while the cloud provider rejects the credentials of the controller, 0 otherwise.",
+	}, []string{"provider"})
+
+	// Leader 1 when this process holds the leader lease
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 when this process holds the leader lease, 0 otherwise.",
+	})
+
+	// LastResyncTimestamp time of the last resync that enqueued every node
+	LastResyncTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_resync_timestamp_seconds",
+		Help:      "Unix time of the last resync that enqueued every node.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		NodeChecks,
+		NodeDeletions,
+		NodeSkips,
+		NodeErrors,
+		ProviderAPIDuration,
+		ProviderErrors,
+		ProviderAuthFailure,
+		Leader,
+		LastResyncTimestamp,
+	)
+}
+
+// ObserveProviderCall record the latency and result of a cloud API call started at start
+func ObserveProviderCall(provider, operation string, start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	ProviderAPIDuration.WithLabelValues(provider, operation, result).Observe(time.Since(start).Seconds())
+}
+
+// Handler http handler exposing the metrics in the prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
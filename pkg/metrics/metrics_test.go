@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gathered the series of the default registry by metric name, each series written as its sorted name=value labels
+func gathered(t *testing.T) map[string][]string {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	series := map[string][]string{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%s", label.GetName(), label.GetValue()))
+			}
+			sort.Strings(labels)
+			series[family.GetName()] = append(series[family.GetName()], strings.Join(labels, ","))
+		}
+	}
+	return series
+}
+
+func hasSeries(series []string, labels string) bool {
+	for _, s := range series {
+		if s == labels {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCollectorsRegistered(t *testing.T) {
+	collectors := []prometheus.Collector{
+		NodeChecks, NodeDeletions, NodeSkips, NodeErrors, ProviderAPIDuration, ProviderErrors, ProviderAuthFailure,
+		Leader, LastResyncTimestamp,
+	}
+	for _, collector := range collectors {
+		err := prometheus.Register(collector)
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			t.Errorf("expected the collector to be registered at init, got %v", err)
+		}
+	}
+	series := gathered(t)
+	for _, name := range []string{namespace + "_leader", namespace + "_last_successful_resync_timestamp_seconds"} {
+		if len(series[name]) != 1 {
+			t.Errorf("expected %s to be exposed, got %v", name, series[name])
+		}
+	}
+}
+
+func TestProviderCallAndSkipLabels(t *testing.T) {
+	ObserveProviderCall("test", "DescribeInstances", time.Now(), nil)
+	ObserveProviderCall("test", "DescribeInstances", time.Now(), fmt.Errorf("boom"))
+	NodeSkips.WithLabelValues("test", "DryRun").Inc()
+
+	series := gathered(t)
+	calls := series[namespace+"_provider_api_duration_seconds"]
+	for _, result := range []string{ResultSuccess, ResultError} {
+		if !hasSeries(calls, "operation=DescribeInstances,provider=test,result="+result) {
+			t.Errorf("expected a %s call series labelled by provider, operation and result, got %v", result, calls)
+		}
+	}
+	if skips := series[namespace+"_node_skips_total"]; !hasSeries(skips, "provider=test,reason=DryRun") {
+		t.Errorf("expected a skip series labelled by provider and reason, got %v", skips)
+	}
+}
+
+func TestWorkqueueMetrics(t *testing.T) {
+	queue := workqueue.NewTypedRateLimitingQueueWithConfig(workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "metrics-test"})
+	defer queue.ShutDown()
+	queue.Add("node-1")
+
+	series := gathered(t)
+	names := []string{"depth", "adds_total", "queue_duration_seconds", "work_duration_seconds", "unfinished_work_seconds",
+		"longest_running_processor_seconds", "retries_total"}
+	for _, name := range names {
+		if !hasSeries(series["workqueue_"+name], "name=metrics-test") {
+			t.Errorf("expected workqueue_%s of the queue to be exposed, got %v", name, series["workqueue_"+name])
+		}
+	}
+}
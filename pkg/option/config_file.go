@@ -0,0 +1,437 @@
+package option
+
+import (
+	"errors"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"os"
+	sigsjson "sigs.k8s.io/json"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// config file version and kind
+const (
+	ConfigAPIVersion = "nodelifecycle/v1alpha1"
+	ConfigKind       = "ControllerConfiguration"
+)
+
+// ConfigFile configuration file of the controller, fields not set in the file keep their flag value
+type ConfigFile struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	Providers []string        `json:"providers,omitempty"`
+	Region    string          `json:"region,omitempty"`
+	AWS       *ProviderConfig `json:"aws,omitempty"`
+	Tencent   *ProviderConfig `json:"tencent,omitempty"`
+	Azure     *AzureConfig    `json:"azure,omitempty"`
+
+	DryRun              *bool            `json:"dryRun,omitempty"`
+	DeletionGracePeriod *metav1.Duration `json:"deletionGracePeriod,omitempty"`
+	Safety              *SafetyConfig    `json:"safety,omitempty"`
+	Drain               *DrainConfig     `json:"drain,omitempty"`
+	Batch               *BatchConfig     `json:"batch,omitempty"`
+	CloudCallTimeout    *metav1.Duration `json:"cloudCallTimeout,omitempty"`
+	Nodes               *NodesConfig     `json:"nodes,omitempty"`
+
+	Workers      *int             `json:"workers,omitempty"`
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+	HTTP         *HTTPConfig      `json:"http,omitempty"`
+}
+
+// ProviderConfig region and credentials of a cloud provider
+type ProviderConfig struct {
+	Region      string          `json:"region,omitempty"`
+	Credentials *CredentialsRef `json:"credentials,omitempty"`
+	RoleARN     string          `json:"roleARN,omitempty"`
+	ExternalID  string          `json:"externalID,omitempty"`
+	Endpoint    string          `json:"endpoint,omitempty"`
+
+	AllowedRegions []string `json:"allowedRegions,omitempty"`
+}
+
+// providerFlags command line flags of the settings of a cloud provider
+type providerFlags struct {
+	region, accessKeyID, secretKey, roleARN, externalID, endpoint, allowedRegions string
+}
+
+// CredentialsRef where to read the credentials of a cloud provider, secrets are never inlined in the file
+type CredentialsRef struct {
+	AccessKeyIDFile string `json:"accessKeyIDFile,omitempty"`
+	SecretKeyFile   string `json:"secretKeyFile,omitempty"`
+	AccessKeyIDEnv  string `json:"accessKeyIDEnv,omitempty"`
+	SecretKeyEnv    string `json:"secretKeyEnv,omitempty"`
+}
+
+// AzureConfig settings of the Azure cloud provider
+type AzureConfig struct {
+	SubscriptionID       string                 `json:"subscriptionID,omitempty"`
+	AllowedSubscriptions []string               `json:"allowedSubscriptions,omitempty"`
+	Cloud                string                 `json:"cloud,omitempty"`
+	ARMEndpoint          string                 `json:"armEndpoint,omitempty"`
+	ARMAudience          string                 `json:"armAudience,omitempty"`
+	AuthorityHost        string                 `json:"authorityHost,omitempty"`
+	Credential           *AzureCredentialConfig `json:"credential,omitempty"`
+}
+
+// AzureCredentialConfig identity of the Azure cloud provider, secrets are read from mounted files
+type AzureCredentialConfig struct {
+	Type                    string `json:"type,omitempty"`
+	ClientID                string `json:"clientID,omitempty"`
+	TenantID                string `json:"tenantID,omitempty"`
+	TokenFile               string `json:"tokenFile,omitempty"`
+	ClientSecretFile        string `json:"clientSecretFile,omitempty"`
+	CertificateFile         string `json:"certificateFile,omitempty"`
+	CertificatePasswordFile string `json:"certificatePasswordFile,omitempty"`
+}
+
+// SafetyConfig limits of the mass-deletion circuit breaker
+type SafetyConfig struct {
+	MaxDeletionsPerInterval *int             `json:"maxDeletionsPerInterval,omitempty"`
+	MaxDeletionPercentage   *int             `json:"maxDeletionPercentage,omitempty"`
+	PercentageMinNodes      *int             `json:"percentageMinNodes,omitempty"`
+	Interval                *metav1.Duration `json:"interval,omitempty"`
+	ResetTimeout            *metav1.Duration `json:"resetTimeout,omitempty"`
+}
+
+// DrainConfig drain of the nodes before they are deleted
+type DrainConfig struct {
+	Enabled *bool            `json:"enabled,omitempty"`
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// BatchConfig coalescing of the instance lookups into batched cloud calls
+type BatchConfig struct {
+	Window  *metav1.Duration `json:"window,omitempty"`
+	MaxSize *int             `json:"maxSize,omitempty"`
+}
+
+// NodesConfig selection of the nodes the controller acts on
+type NodesConfig struct {
+	Selector            string   `json:"selector,omitempty"`
+	ExcludeSelector     string   `json:"excludeSelector,omitempty"`
+	ExcludeNames        []string `json:"excludeNames,omitempty"`
+	IncludeControlPlane *bool    `json:"includeControlPlane,omitempty"`
+}
+
+// HTTPConfig settings of the http server
+type HTTPConfig struct {
+	Port           string `json:"port,omitempty"`
+	AdminTokenFile string `json:"adminTokenFile,omitempty"`
+}
+
+// LoadConfigFile read, strictly decode and validate a config file
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file %s: %w", path, err)
+	}
+	data, err = yaml.YAMLToJSONStrict(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode config file %s: %w", path, err)
+	}
+	file := &ConfigFile{}
+	strictErrs, err := sigsjson.UnmarshalStrict(data, file, sigsjson.DisallowDuplicateFields, sigsjson.DisallowUnknownFields)
+	if err != nil {
+		return nil, fmt.Errorf("decode config file %s: %w", path, err)
+	}
+	if len(strictErrs) > 0 {
+		return nil, fmt.Errorf("decode config file %s: %w", path, errors.Join(strictErrs...))
+	}
+	if err := file.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Validate check the config file version and value ranges
+func (f *ConfigFile) Validate() error {
+	if f.APIVersion != ConfigAPIVersion {
+		return fmt.Errorf("apiVersion must be %s, got %q", ConfigAPIVersion, f.APIVersion)
+	}
+	if f.Kind != ConfigKind {
+		return fmt.Errorf("kind must be %s, got %q", ConfigKind, f.Kind)
+	}
+	for _, p := range []struct {
+		name   string
+		config *ProviderConfig
+	}{{"aws", f.AWS}, {"tencent", f.Tencent}} {
+		if p.config == nil || p.config.Credentials == nil {
+			continue
+		}
+		ref := p.config.Credentials
+		if ref.AccessKeyIDFile != "" && ref.AccessKeyIDEnv != "" {
+			return fmt.Errorf("%s.credentials: accessKeyIDFile and accessKeyIDEnv are mutually exclusive", p.name)
+		}
+		if ref.SecretKeyFile != "" && ref.SecretKeyEnv != "" {
+			return fmt.Errorf("%s.credentials: secretKeyFile and secretKeyEnv are mutually exclusive", p.name)
+		}
+	}
+	if f.AWS != nil && f.AWS.Endpoint != "" {
+		return fmt.Errorf("aws: endpoint is only supported by tencent")
+	}
+	if f.DeletionGracePeriod != nil && f.DeletionGracePeriod.Duration < 0 {
+		return fmt.Errorf("deletionGracePeriod can't be negative")
+	}
+	if s := f.Safety; s != nil {
+		if s.MaxDeletionsPerInterval != nil && *s.MaxDeletionsPerInterval < 0 {
+			return fmt.Errorf("safety.maxDeletionsPerInterval can't be negative")
+		}
+		if s.MaxDeletionPercentage != nil && (*s.MaxDeletionPercentage < 0 || *s.MaxDeletionPercentage > 100) {
+			return fmt.Errorf("safety.maxDeletionPercentage must be between 0 and 100")
+		}
+		if s.PercentageMinNodes != nil && *s.PercentageMinNodes < 0 {
+			return fmt.Errorf("safety.percentageMinNodes can't be negative")
+		}
+		if s.Interval != nil && s.Interval.Duration <= 0 {
+			return fmt.Errorf("safety.interval must be positive")
+		}
+		if s.ResetTimeout != nil && s.ResetTimeout.Duration < 0 {
+			return fmt.Errorf("safety.resetTimeout can't be negative")
+		}
+	}
+	if f.Drain != nil && f.Drain.Timeout != nil && f.Drain.Timeout.Duration <= 0 {
+		return fmt.Errorf("drain.timeout must be positive")
+	}
+	if b := f.Batch; b != nil {
+		if b.Window != nil && b.Window.Duration < 0 {
+			return fmt.Errorf("batch.window can't be negative")
+		}
+		if b.MaxSize != nil && *b.MaxSize < 1 {
+			return fmt.Errorf("batch.maxSize must be at least 1")
+		}
+	}
+	if f.CloudCallTimeout != nil && f.CloudCallTimeout.Duration <= 0 {
+		return fmt.Errorf("cloudCallTimeout must be positive")
+	}
+	if n := f.Nodes; n != nil {
+		if _, err := labels.Parse(n.Selector); err != nil {
+			return fmt.Errorf("nodes.selector: %w", err)
+		}
+		if _, err := labels.Parse(n.ExcludeSelector); err != nil {
+			return fmt.Errorf("nodes.excludeSelector: %w", err)
+		}
+		if err := ValidateNamePatterns(n.ExcludeNames); err != nil {
+			return fmt.Errorf("nodes.excludeNames: %w", err)
+		}
+	}
+	if f.Workers != nil && *f.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
+	if f.ResyncPeriod != nil && f.ResyncPeriod.Duration <= 0 {
+		return fmt.Errorf("resyncPeriod must be positive")
+	}
+	return nil
+}
+
+// ApplyTo copy the settings of the file into the options, except the ones whose flag was set on the command line
+func (f *ConfigFile) ApplyTo(o *Options, flagChanged func(name string) bool) error {
+	set := func(flag string, apply func()) {
+		if !flagChanged(flag) {
+			apply()
+		}
+	}
+	if len(f.Providers) > 0 {
+		set("cloud-provider", func() { o.CloudProvider = strings.Join(f.Providers, ",") })
+	}
+	if f.Region != "" {
+		set("region", func() { o.Region = f.Region })
+	}
+	awsFlags := providerFlags{
+		region:         "aws-region",
+		accessKeyID:    "aws-access-key-id",
+		secretKey:      "aws-secret-key-id",
+		roleARN:        "aws-role-arn",
+		externalID:     "aws-external-id",
+		allowedRegions: "aws-allowed-regions",
+	}
+	if err := applyProviderConfig(f.AWS, &o.AWS, awsFlags, set); err != nil {
+		return fmt.Errorf("aws: %w", err)
+	}
+	tencentFlags := providerFlags{
+		region:         "tencent-region",
+		accessKeyID:    "tencent-secret-id",
+		secretKey:      "tencent-secret-key",
+		roleARN:        "tencent-role-arn",
+		externalID:     "tencent-external-id",
+		endpoint:       "tencent-endpoint",
+		allowedRegions: "tencent-allowed-regions",
+	}
+	if err := applyProviderConfig(f.Tencent, &o.Tencent, tencentFlags, set); err != nil {
+		return fmt.Errorf("tencent: %w", err)
+	}
+	applyAzureConfig(f.Azure, &o.Azure, set)
+	if f.DryRun != nil {
+		set("dry-run", func() { o.DryRun = *f.DryRun })
+	}
+	if f.DeletionGracePeriod != nil {
+		set("deletion-grace-period", func() { o.DeletionGracePeriod = f.DeletionGracePeriod.Duration })
+	}
+	if s := f.Safety; s != nil {
+		if s.MaxDeletionsPerInterval != nil {
+			set("max-deletions-per-interval", func() { o.MaxDeletionsPerInterval = *s.MaxDeletionsPerInterval })
+		}
+		if s.MaxDeletionPercentage != nil {
+			set("max-deletion-percentage", func() { o.MaxDeletionPercentage = *s.MaxDeletionPercentage })
+		}
+		if s.PercentageMinNodes != nil {
+			set("deletion-percentage-min-nodes", func() { o.PercentageMinNodes = *s.PercentageMinNodes })
+		}
+		if s.Interval != nil {
+			set("deletion-interval", func() { o.DeletionInterval = s.Interval.Duration })
+		}
+		if s.ResetTimeout != nil {
+			set("circuit-breaker-reset-timeout", func() { o.CircuitBreakerResetTimeout = s.ResetTimeout.Duration })
+		}
+	}
+	if d := f.Drain; d != nil {
+		if d.Enabled != nil {
+			set("drain-before-delete", func() { o.DrainBeforeDelete = *d.Enabled })
+		}
+		if d.Timeout != nil {
+			set("drain-timeout", func() { o.DrainTimeout = d.Timeout.Duration })
+		}
+	}
+	if b := f.Batch; b != nil {
+		if b.Window != nil {
+			set("batch-window", func() { o.BatchWindow = b.Window.Duration })
+		}
+		if b.MaxSize != nil {
+			set("batch-size", func() { o.BatchSize = *b.MaxSize })
+		}
+	}
+	if f.CloudCallTimeout != nil {
+		set("cloud-call-timeout", func() { o.CloudCallTimeout = f.CloudCallTimeout.Duration })
+	}
+	if n := f.Nodes; n != nil {
+		if n.Selector != "" {
+			set("node-selector", func() { o.NodeSelector = n.Selector })
+		}
+		if n.ExcludeSelector != "" {
+			set("exclude-node-selector", func() { o.ExcludeNodeSelector = n.ExcludeSelector })
+		}
+		if len(n.ExcludeNames) > 0 {
+			set("exclude-node-names", func() { o.ExcludeNodeNames = n.ExcludeNames })
+		}
+		if n.IncludeControlPlane != nil {
+			set("include-control-plane", func() { o.IncludeControlPlane = *n.IncludeControlPlane })
+		}
+	}
+	if f.Workers != nil {
+		set("workers", func() { o.Workers = *f.Workers })
+	}
+	if f.ResyncPeriod != nil {
+		set("resync-period", func() { o.ResyncPeriod = f.ResyncPeriod.Duration })
+	}
+	if h := f.HTTP; h != nil {
+		if h.Port != "" {
+			set("port", func() { o.Port = h.Port })
+		}
+		if h.AdminTokenFile != "" {
+			set("admin-token-file", func() { o.AdminTokenFile = h.AdminTokenFile })
+		}
+	}
+	return nil
+}
+
+// applyProviderConfig copy region, role and the referenced credentials of a provider
+func applyProviderConfig(config *ProviderConfig, o *ProviderOptions, flags providerFlags, set func(string, func())) error {
+	if config == nil {
+		return nil
+	}
+	if config.Region != "" {
+		set(flags.region, func() { o.Region = config.Region })
+	}
+	if len(config.AllowedRegions) > 0 {
+		set(flags.allowedRegions, func() { o.AllowedRegions = config.AllowedRegions })
+	}
+	if config.RoleARN != "" {
+		set(flags.roleARN, func() { o.RoleARN = config.RoleARN })
+	}
+	if config.ExternalID != "" {
+		set(flags.externalID, func() { o.ExternalID = config.ExternalID })
+	}
+	if config.Endpoint != "" {
+		set(flags.endpoint, func() { o.Endpoint = config.Endpoint })
+	}
+	if config.Credentials == nil {
+		return nil
+	}
+	accessKeyID, err := readCredential(config.Credentials.AccessKeyIDFile, config.Credentials.AccessKeyIDEnv)
+	if err != nil {
+		return err
+	}
+	secretKey, err := readCredential(config.Credentials.SecretKeyFile, config.Credentials.SecretKeyEnv)
+	if err != nil {
+		return err
+	}
+	if accessKeyID != "" {
+		set(flags.accessKeyID, func() { o.AccessKeyID = accessKeyID })
+	}
+	if secretKey != "" {
+		set(flags.secretKey, func() { o.SecretKeyID = secretKey })
+	}
+	return nil
+}
+
+// stringSetting a string setting of the file and the flag it maps to
+type stringSetting struct {
+	flag  string
+	value string
+	field *string
+}
+
+// applyAzureConfig apply the azure settings of the file, the string settings map one to one to their flags
+func applyAzureConfig(config *AzureConfig, o *AzureOptions, set func(string, func())) {
+	if config == nil {
+		return
+	}
+	if len(config.AllowedSubscriptions) > 0 {
+		set("azure-allowed-subscriptions", func() { o.AllowedSubscriptions = config.AllowedSubscriptions })
+	}
+	settings := []stringSetting{
+		{"azure-subscription-id", config.SubscriptionID, &o.SubscriptionID},
+		{"azure-cloud", config.Cloud, &o.Cloud},
+		{"azure-arm-endpoint", config.ARMEndpoint, &o.ARMEndpoint},
+		{"azure-arm-audience", config.ARMAudience, &o.ARMAudience},
+		{"azure-authority-host", config.AuthorityHost, &o.AuthorityHost},
+	}
+	if c := config.Credential; c != nil {
+		settings = append(settings, []stringSetting{
+			{"azure-credential-type", c.Type, &o.CredentialType},
+			{"azure-client-id", c.ClientID, &o.ClientID},
+			{"azure-tenant-id", c.TenantID, &o.TenantID},
+			{"azure-federated-token-file", c.TokenFile, &o.TokenFile},
+			{"azure-client-secret-file", c.ClientSecretFile, &o.ClientSecretFile},
+			{"azure-client-certificate-file", c.CertificateFile, &o.CertificateFile},
+			{"azure-client-certificate-password-file", c.CertificatePasswordFile, &o.CertificatePasswordFile},
+		}...)
+	}
+	for _, s := range settings {
+		if s.value != "" {
+			value, field := s.value, s.field
+			set(s.flag, func() { *field = value })
+		}
+	}
+}
+
+// readCredential read a credential from a mounted file or an environment variable
+func readCredential(file, env string) (string, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("read credential file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if env != "" {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("credential environment variable %s is not set", env)
+		}
+		return value, nil
+	}
+	return "", nil
+}
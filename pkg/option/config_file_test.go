@@ -0,0 +1,149 @@
+package option
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFileAndApply(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+	t.Setenv("TEST_AWS_ACCESS_KEY_ID", "AKIA")
+	path := writeConfigFile(t, `
+apiVersion: nodelifecycle/v1alpha1
+kind: ControllerConfiguration
+providers: [aws, tencent]
+aws:
+  region: us-west-2
+  credentials:
+    accessKeyIDEnv: TEST_AWS_ACCESS_KEY_ID
+    secretKeyFile: `+secretFile+`
+tencent:
+  region: ap-singapore
+  roleARN: qcs::cam::uin/100000000001:roleName/nodes
+  endpoint: cvm.internal.tencentcloudapi.com
+deletionGracePeriod: 2m
+safety:
+  maxDeletionsPerInterval: 3
+  percentageMinNodes: 20
+  interval: 5m
+drain:
+  enabled: true
+  timeout: 1m
+nodes:
+  selector: node.kubernetes.io/lifecycle=spot
+  excludeSelector: pool=system
+  excludeNames: [infra-*]
+  includeControlPlane: true
+workers: 10
+http:
+  port: "9090"
+`)
+	file, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	o := Options{Port: "8080", Workers: 5, MaxDeletionsPerInterval: 10, MaxDeletionPercentage: 50}
+	changed := map[string]bool{"workers": true}
+	if err := file.ApplyTo(&o, func(name string) bool { return changed[name] }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.CloudProvider != "aws,tencent" {
+		t.Errorf("expected providers aws,tencent, got %q", o.CloudProvider)
+	}
+	if o.AWS.Region != "us-west-2" || o.AWS.AccessKeyID != "AKIA" || o.AWS.SecretKeyID != "s3cr3t" {
+		t.Errorf("unexpected aws options: %+v", o.AWS)
+	}
+	if o.Tencent.Region != "ap-singapore" || o.Tencent.RoleARN == "" || o.Tencent.Endpoint != "cvm.internal.tencentcloudapi.com" {
+		t.Errorf("unexpected tencent options: %+v", o.Tencent)
+	}
+	if o.DeletionGracePeriod != 2*time.Minute || o.MaxDeletionsPerInterval != 3 || o.PercentageMinNodes != 20 || o.DeletionInterval != 5*time.Minute {
+		t.Errorf("unexpected deletion settings: %+v", o)
+	}
+	if !o.DrainBeforeDelete || o.DrainTimeout != time.Minute {
+		t.Errorf("unexpected drain settings: enabled=%v timeout=%s", o.DrainBeforeDelete, o.DrainTimeout)
+	}
+	if o.MaxDeletionPercentage != 50 {
+		t.Errorf("expected unset field to keep its flag value, got %d", o.MaxDeletionPercentage)
+	}
+	if o.NodeSelector != "node.kubernetes.io/lifecycle=spot" || o.ExcludeNodeSelector != "pool=system" || !o.IncludeControlPlane ||
+		len(o.ExcludeNodeNames) != 1 || o.ExcludeNodeNames[0] != "infra-*" {
+		t.Errorf("unexpected node selection: selector=%q exclude=%q names=%v controlPlane=%v", o.NodeSelector, o.ExcludeNodeSelector, o.ExcludeNodeNames, o.IncludeControlPlane)
+	}
+	if o.Workers != 5 {
+		t.Errorf("expected flag set on the command line to win, got %d workers", o.Workers)
+	}
+	if o.Port != "9090" {
+		t.Errorf("expected port 9090, got %q", o.Port)
+	}
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	cases := map[string]string{
+		"wrong version": `
+apiVersion: nodelifecycle/v2
+kind: ControllerConfiguration
+`,
+		"unknown field": `
+apiVersion: nodelifecycle/v1alpha1
+kind: ControllerConfiguration
+dryrun: true
+`,
+		"inline secret": `
+apiVersion: nodelifecycle/v1alpha1
+kind: ControllerConfiguration
+aws:
+  credentials:
+    secretKey: xxx
+`,
+		"percentage out of range": `
+apiVersion: nodelifecycle/v1alpha1
+kind: ControllerConfiguration
+safety:
+  maxDeletionPercentage: 150
+`,
+		"aws endpoint": `
+apiVersion: nodelifecycle/v1alpha1
+kind: ControllerConfiguration
+aws:
+  endpoint: ec2.us-west-2.amazonaws.com
+`,
+		"invalid node selector": `
+apiVersion: nodelifecycle/v1alpha1
+kind: ControllerConfiguration
+nodes:
+  excludeSelector: "pool in (system"
+`,
+		"invalid node name pattern": `
+apiVersion: nodelifecycle/v1alpha1
+kind: ControllerConfiguration
+nodes:
+  excludeNames: ["gpu-["]
+`,
+		"no workers": `
+apiVersion: nodelifecycle/v1alpha1
+kind: ControllerConfiguration
+workers: 0
+`,
+	}
+	for name, content := range cases {
+		if _, err := LoadConfigFile(writeConfigFile(t, strings.TrimSpace(content))); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
@@ -1,13 +1,261 @@
 package option
 
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/labels"
+	"path"
+	"strings"
+	"time"
+)
+
 // Options struct
 type Options struct {
 	KubeConfig     string
 	InCluster      bool
-	CloudProvider  string
-	Region         string
-	AccessKeyID    string
-	SecretKeyID    string
+	CloudProvider  string // comma separated list of enabled cloud providers
+	Region         string // default region of the providers
+	AccessKeyID    string // default access key id of the providers
+	SecretKeyID    string // default secret of the providers
 	Port           string
+	AdminTokenFile string // bearer token of the admin endpoints, they are disabled without it
 	SubscriptionID string // For Azure provider
+	DryRun         bool
+	ConfigFile     string
+	Workers        int
+	ResyncPeriod   time.Duration
+
+	AWS     ProviderOptions
+	Tencent ProviderOptions
+	Azure   AzureOptions
+
+	DeletionGracePeriod time.Duration
+
+	MaxDeletionsPerInterval    int
+	MaxDeletionPercentage      int
+	PercentageMinNodes         int // the percentage limit only applies to clusters of at least this many nodes
+	DeletionInterval           time.Duration
+	CircuitBreakerResetTimeout time.Duration
+
+	DrainBeforeDelete bool
+	DrainTimeout      time.Duration
+
+	BatchWindow time.Duration // how long an instance lookup waits for others to join its batch
+	BatchSize   int           // max nodes looked up in one batch
+
+	CloudCallTimeout time.Duration // max duration of a cloud API call, a batch is one call
+
+	NodeSelector        string   // label selector of the watched nodes
+	ExcludeNodeSelector string   // label selector of the watched nodes the controller ignores
+	ExcludeNodeNames    []string // name patterns of the watched nodes the controller ignores, e.g. gpu-*
+	IncludeControlPlane bool     // act on the control-plane nodes too
+}
+
+// ProviderOptions region and credentials of a cloud provider,
+// without static keys the provider falls back to its default credential chain
+type ProviderOptions struct {
+	Region      string
+	AccessKeyID string
+	SecretKeyID string
+	RoleARN     string // role assumed with the credentials
+	ExternalID  string // external id required by the trust policy of the role
+	Endpoint    string // API endpoint, tencent only
+
+	AllowedRegions []string // regions of the nodes the provider acts on, defaults to Region
+}
+
+// Regions regions of the nodes the provider acts on
+func (p *ProviderOptions) Regions() []string {
+	if len(p.AllowedRegions) > 0 {
+		return p.AllowedRegions
+	}
+	return []string{p.Region}
+}
+
+// AzureOptions settings of the Azure cloud provider, the subscription of each VM is read from the providerID of its node
+type AzureOptions struct {
+	SubscriptionID       string
+	AllowedSubscriptions []string // subscriptions of the nodes the provider acts on, defaults to SubscriptionID
+
+	Cloud         string // public, china or usgov
+	ARMEndpoint   string // custom resource manager endpoint, overrides Cloud
+	ARMAudience   string // token audience of the custom endpoint, defaults to the endpoint
+	AuthorityHost string // Microsoft Entra authority host of the custom endpoint
+
+	CredentialType          string // default, managed-identity, workload-identity, client-secret or client-certificate
+	ClientID                string
+	TenantID                string
+	TokenFile               string // service account token file of the workload identity
+	ClientSecretFile        string
+	CertificateFile         string // PEM or PKCS#12 certificate with its private key
+	CertificatePasswordFile string
+}
+
+// Subscriptions subscriptions of the nodes the provider acts on, empty if any subscription is allowed
+func (a *AzureOptions) Subscriptions() []string {
+	if len(a.AllowedSubscriptions) > 0 {
+		return a.AllowedSubscriptions
+	}
+	if a.SubscriptionID != "" {
+		return []string{a.SubscriptionID}
+	}
+	return nil
+}
+
+// CloudProviders enabled cloud providers
+func (o *Options) CloudProviders() []string {
+	var providers []string
+	for _, name := range strings.Split(o.CloudProvider, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			providers = append(providers, name)
+		}
+	}
+	return providers
+}
+
+// Complete fill the provider settings that are not set with the shared defaults
+func (o *Options) Complete() {
+	for _, p := range []*ProviderOptions{&o.AWS, &o.Tencent} {
+		if p.Region == "" {
+			p.Region = o.Region
+		}
+		if p.AccessKeyID == "" {
+			p.AccessKeyID = o.AccessKeyID
+		}
+		if p.SecretKeyID == "" {
+			p.SecretKeyID = o.SecretKeyID
+		}
+	}
+	if o.Azure.SubscriptionID == "" {
+		o.Azure.SubscriptionID = o.SubscriptionID
+	}
+}
+
+// CopyReloadable copy the settings that can be changed without restarting the controller
+func (o *Options) CopyReloadable(from *Options) {
+	o.DryRun = from.DryRun
+	o.DeletionGracePeriod = from.DeletionGracePeriod
+	o.MaxDeletionsPerInterval = from.MaxDeletionsPerInterval
+	o.MaxDeletionPercentage = from.MaxDeletionPercentage
+	o.PercentageMinNodes = from.PercentageMinNodes
+	o.DeletionInterval = from.DeletionInterval
+	o.CircuitBreakerResetTimeout = from.CircuitBreakerResetTimeout
+	o.DrainBeforeDelete = from.DrainBeforeDelete
+	o.DrainTimeout = from.DrainTimeout
+	o.ExcludeNodeSelector = from.ExcludeNodeSelector
+	o.ExcludeNodeNames = from.ExcludeNodeNames
+	o.IncludeControlPlane = from.IncludeControlPlane
+}
+
+// Validate check the settings of the enabled cloud providers
+func (o *Options) Validate() error {
+	providers := o.CloudProviders()
+	if len(providers) == 0 {
+		return fmt.Errorf("cloud provider can't be empty")
+	}
+	if o.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
+	if o.ResyncPeriod <= 0 {
+		return fmt.Errorf("resync period must be positive")
+	}
+	if o.DeletionInterval <= 0 {
+		return fmt.Errorf("deletion interval must be positive")
+	}
+	if o.BatchSize < 1 {
+		return fmt.Errorf("batch size must be at least 1")
+	}
+	if o.BatchWindow < 0 {
+		return fmt.Errorf("batch window can't be negative")
+	}
+	if o.CloudCallTimeout <= 0 {
+		return fmt.Errorf("cloud call timeout must be positive")
+	}
+	if o.DrainTimeout <= 0 {
+		return fmt.Errorf("drain timeout must be positive")
+	}
+	if o.MaxDeletionPercentage < 0 || o.MaxDeletionPercentage > 100 {
+		return fmt.Errorf("max deletion percentage must be between 0 and 100")
+	}
+	if o.PercentageMinNodes < 0 {
+		return fmt.Errorf("deletion percentage min nodes can't be negative")
+	}
+	if _, err := labels.Parse(o.NodeSelector); err != nil {
+		return fmt.Errorf("invalid node selector: %w", err)
+	}
+	if _, err := labels.Parse(o.ExcludeNodeSelector); err != nil {
+		return fmt.Errorf("invalid exclude node selector: %w", err)
+	}
+	if err := ValidateNamePatterns(o.ExcludeNodeNames); err != nil {
+		return fmt.Errorf("invalid exclude node names: %w", err)
+	}
+	for _, name := range providers {
+		switch name {
+		case "aws":
+			if o.AWS.Region == "" {
+				return fmt.Errorf("region can't be empty for cloud provider %s", name)
+			}
+			if err := o.AWS.validateCredentials(); err != nil {
+				return fmt.Errorf("cloud provider %s: %w", name, err)
+			}
+		case "azure":
+			if err := o.Azure.validate(); err != nil {
+				return fmt.Errorf("cloud provider %s: %w", name, err)
+			}
+		case "tencent":
+			if o.Tencent.Region == "" {
+				return fmt.Errorf("region can't be empty for cloud provider %s", name)
+			}
+			if err := o.Tencent.validateCredentials(); err != nil {
+				return fmt.Errorf("cloud provider %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateCredentials static keys go in pairs and an external id is only used to assume a role
+func (p *ProviderOptions) validateCredentials() error {
+	if (p.AccessKeyID == "") != (p.SecretKeyID == "") {
+		return fmt.Errorf("access key id and secret must be set together")
+	}
+	if p.ExternalID != "" && p.RoleARN == "" {
+		return fmt.Errorf("external id requires a role arn")
+	}
+	return nil
+}
+
+// validate check the cloud and the settings required by the credential type
+func (a *AzureOptions) validate() error {
+	switch strings.ToLower(a.Cloud) {
+	case "", "public", "china", "usgov":
+	default:
+		return fmt.Errorf("unknown cloud %q, supported clouds: public, china, usgov", a.Cloud)
+	}
+	if a.ARMEndpoint != "" && a.AuthorityHost == "" {
+		return fmt.Errorf("a custom ARM endpoint requires the authority host")
+	}
+	switch a.CredentialType {
+	case "", "default", "managed-identity", "workload-identity":
+	case "client-secret":
+		if a.TenantID == "" || a.ClientID == "" || a.ClientSecretFile == "" {
+			return fmt.Errorf("credential type client-secret requires the tenant id, the client id and the client secret file")
+		}
+	case "client-certificate":
+		if a.TenantID == "" || a.ClientID == "" || a.CertificateFile == "" {
+			return fmt.Errorf("credential type client-certificate requires the tenant id, the client id and the certificate file")
+		}
+	default:
+		return fmt.Errorf("unknown credential type %q", a.CredentialType)
+	}
+	return nil
+}
+
+// ValidateNamePatterns check the node name patterns, their syntax is the one of path.Match
+func ValidateNamePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
 }
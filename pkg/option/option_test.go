@@ -0,0 +1,46 @@
+package option
+
+import "testing"
+
+func TestValidateAWSCredentials(t *testing.T) {
+	cases := []struct {
+		name    string
+		aws     ProviderOptions
+		wantErr bool
+	}{
+		{name: "default credential chain", aws: ProviderOptions{Region: "us-west-2"}},
+		{name: "static keys", aws: ProviderOptions{Region: "us-west-2", AccessKeyID: "AKIA", SecretKeyID: "secret"}},
+		{name: "assume role", aws: ProviderOptions{Region: "us-west-2", RoleARN: "arn:aws:iam::123456789012:role/nodes", ExternalID: "id"}},
+		{name: "access key without secret", aws: ProviderOptions{Region: "us-west-2", AccessKeyID: "AKIA"}, wantErr: true},
+		{name: "external id without role", aws: ProviderOptions{Region: "us-west-2", ExternalID: "id"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		o := Options{CloudProvider: "aws", Workers: 1, ResyncPeriod: 1, DeletionInterval: 1, DrainTimeout: 1, BatchSize: 1, CloudCallTimeout: 1, AWS: tc.aws}
+		if err := o.Validate(); (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func TestValidateAzureCredentials(t *testing.T) {
+	cases := []struct {
+		name    string
+		azure   AzureOptions
+		wantErr bool
+	}{
+		{name: "default credential", azure: AzureOptions{Cloud: "public", CredentialType: "default"}},
+		{name: "sovereign cloud", azure: AzureOptions{Cloud: "china", CredentialType: "managed-identity", ClientID: "id"}},
+		{name: "client secret", azure: AzureOptions{CredentialType: "client-secret", TenantID: "t", ClientID: "c", ClientSecretFile: "/secret"}},
+		{name: "client secret without file", azure: AzureOptions{CredentialType: "client-secret", TenantID: "t", ClientID: "c"}, wantErr: true},
+		{name: "certificate without tenant", azure: AzureOptions{CredentialType: "client-certificate", ClientID: "c", CertificateFile: "/cert"}, wantErr: true},
+		{name: "custom endpoint without authority", azure: AzureOptions{ARMEndpoint: "https://management.local"}, wantErr: true},
+		{name: "unknown cloud", azure: AzureOptions{Cloud: "germany"}, wantErr: true},
+		{name: "unknown credential type", azure: AzureOptions{CredentialType: "password"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		o := Options{CloudProvider: "azure", Workers: 1, ResyncPeriod: 1, DeletionInterval: 1, DrainTimeout: 1, BatchSize: 1, CloudCallTimeout: 1, Azure: tc.azure}
+		if err := o.Validate(); (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
@@ -2,24 +2,138 @@ package aws
 
 import (
 	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/metrics"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
+	"net"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Aws aws cloud provider
+// maxBatchSize max instance ids per DescribeInstances call
+const maxBatchSize = 100
+
+// EC2 error codes
+const (
+	errCodeDryRunOperation   = "DryRunOperation"             // a dry run call that would have succeeded
+	errCodeInstanceNotFound  = "InvalidInstanceID.NotFound"  // the instance doesn't exist
+	errCodeInstanceMalformed = "InvalidInstanceID.Malformed" // the instance id of the providerID is invalid
+)
+
+// zoneRegionPattern region prefix of an availability, local or wavelength zone,
+// e.g. us-east-1 of us-east-1a, us-west-2 of us-west-2-lax-1a
+var zoneRegionPattern = regexp.MustCompile(`^([a-z]{2}(?:-[a-z]+)+-\d+)`)
+
+// Aws aws cloud provider, the session and the EC2 clients are safe for concurrent use and shared by all checks
 type Aws struct {
+	session        *session.Session
+	regions        []string // allowed regions, in the order of the options
+	allowedRegions map[string]bool
+
+	mu      sync.Mutex
+	clients map[string]*ec2.EC2 // keyed by region
 }
 
-// InitAwsCloudProvider init aws cloud provider
+// InitAwsCloudProvider init aws cloud provider with a long-lived session and the EC2 client of the configured region.
+// The credentials are checked so a misconfiguration fails at startup
 func InitAwsCloudProvider() (*Aws, error) {
-	return &Aws{}, nil
+	sess, err := newSession(config.Options.AWS)
+	if err != nil {
+		return nil, fmt.Errorf("create aws session: %w", err)
+	}
+	a := newAws(config.Options.AWS, sess)
+	client, err := a.client(config.Options.AWS.Region)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCredential(client, config.Options.CloudCallTimeout); err != nil {
+		return nil, fmt.Errorf("invalid aws credentials: %w", err)
+	}
+	return a, nil
+}
+
+// newAws create the provider with its session, the EC2 clients are created on first use
+func newAws(o option.ProviderOptions, sess *session.Session) *Aws {
+	a := &Aws{
+		session:        sess,
+		regions:        o.Regions(),
+		allowedRegions: map[string]bool{},
+		clients:        map[string]*ec2.EC2{},
+	}
+	for _, region := range a.regions {
+		a.allowedRegions[region] = true
+	}
+	return a
+}
+
+// newSession create a session with the static keys if set, otherwise with the default credential chain:
+// environment, web identity token file (IRSA), shared config profile, ECS and EC2 instance metadata.
+// With a role arn the session assumes the role, the STS credentials are refreshed before they expire
+func newSession(o option.ProviderOptions) (*session.Session, error) {
+	cfg := aws.NewConfig().WithRegion(o.Region)
+	if o.AccessKeyID != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(o.AccessKeyID, o.SecretKeyID, ""))
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if o.RoleARN == "" {
+		return sess, nil
+	}
+	klog.Infof("aws credentials assume role %s", o.RoleARN)
+	assumed := stscreds.NewCredentials(sess, o.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = "cloud-node-lifecycle-controller"
+		if o.ExternalID != "" {
+			p.ExternalID = aws.String(o.ExternalID)
+		}
+	})
+	return sess.Copy(aws.NewConfig().WithCredentials(assumed)), nil
+}
+
+// checkCredential dry run DescribeInstances against the EC2 endpoint the node checks use, with the permission they need,
+// so a misconfigured credential or role fails at startup instead of on the first node check
+func checkCredential(client *ec2.EC2, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := client.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{DryRun: aws.Bool(true)})
+	var awsErr awserr.Error
+	if err == nil || errors.As(err, &awsErr) && awsErr.Code() == errCodeDryRunOperation {
+		return nil
+	}
+	return fmt.Errorf("describe instances: %w", err)
+}
+
+// client return the EC2 client of a region, created on first use and cached
+func (a *Aws) client(region string) (*ec2.EC2, error) {
+	if region == "" {
+		return nil, fmt.Errorf("aws region can't be empty")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if client, ok := a.clients[region]; ok {
+		return client, nil
+	}
+	client := ec2.New(a.session, aws.NewConfig().WithRegion(region))
+	a.clients[region] = client
+	return client, nil
 }
 
 // parseInstanceFromProviderID parse instance id from provider id
@@ -33,56 +147,219 @@ func parseInstanceFromProviderID(node *v1.Node) (string, string, error) {
 	return "", "", fmt.Errorf("invalid providerID: %s", providerID)
 }
 
-// CheckNodeInstanceExists check node instance exists
-func (a *Aws) CheckNodeInstanceExists(node *v1.Node) (bool, error) {
+// locate return the region and the instance id of the node, the region is derived from the zone of the providerID
+// and must be allowed: checking an instance in the wrong region would report it missing and delete its node.
+// A region that can't be used is a permanent error, the node is skipped until the configuration changes
+func (a *Aws) locate(node *v1.Node) (string, string, error) {
+	zone, instanceID, err := parseInstanceFromProviderID(node)
+	if err != nil {
+		return "", "", err
+	}
+	match := zoneRegionPattern.FindStringSubmatch(zone)
+	if match == nil {
+		return "", "", types.NewCloudError("aws", types.ErrorPermanent,
+			fmt.Errorf("can't derive the region of zone %q of providerID %s", zone, node.Spec.ProviderID))
+	}
+	region := match[1]
+	if !a.allowedRegions[region] {
+		return "", "", types.NewCloudError("aws", types.ErrorPermanent, fmt.Errorf("region %s of providerID %s is not allowed, allowed regions: %s",
+			region, node.Spec.ProviderID, strings.Join(a.regions, ",")))
+	}
+	return region, instanceID, nil
+}
+
+// GetInstanceStatus get the status of the EC2 instance backing the node
+func (a *Aws) GetInstanceStatus(ctx context.Context, node *v1.Node) (*types.InstanceStatus, error) {
 	providerID := node.Spec.ProviderID
-	_, instanceID, err := parseInstanceFromProviderID(node)
-	region := config.Options.Region
+	region, instanceID, err := a.locate(node)
 	if err != nil {
-		klog.Errorf("Failed to parse instance ID from provider ID %s: %v", providerID, err)
-		return false, err
+		klog.Errorf("Failed to locate instance of provider ID %s: %v", providerID, err)
+		return nil, err
 	}
 	klog.Infof("region: %s, instanceID: %s", region, instanceID)
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(config.Options.AccessKeyID, config.Options.SecretKeyID, ""),
-	})
+	svc, err := a.client(region)
 	if err != nil {
-		klog.Fatalf("Failed to create session: %v", err)
+		return nil, err
 	}
 
-	svc := ec2.New(sess)
-
-	resp, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
+	start := time.Now()
+	resp, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
 		InstanceIds: aws.StringSlice([]string{instanceID}),
 	})
+	metrics.ObserveProviderCall("aws", "DescribeInstances", start, err)
 	if err != nil {
-
-		if awsError, ok := err.(awserr.Error); ok {
-			if awsError.Code() == ec2.UnsuccessfulInstanceCreditSpecificationErrorCodeInvalidInstanceIdNotFound {
-				klog.Infof("Instance %s not found.", instanceID)
-				return false, nil
-			}
-			klog.Errorf("Failed to describe  %s: %v", instanceID, err)
-			return true, err
-		} else if strings.Contains(err.Error(), ec2.UnsuccessfulInstanceCreditSpecificationErrorCodeInvalidInstanceIdNotFound) {
+		err = classifyError(err)
+		if types.IsNotFound(err) {
 			klog.Infof("Instance %s not found.", instanceID)
-			return false, nil
+			return types.NewNotFoundStatus(providerID), nil
 		}
 		klog.Errorf("Failed to describe  %s: %v", instanceID, err)
-		return true, err
-
+		return nil, err
 	}
 
-	if len(resp.Reservations) == 0 {
+	if len(resp.Reservations) == 0 || len(resp.Reservations[0].Instances) == 0 {
 		klog.Infof("Instance %s not found.\n", instanceID)
-		return false, nil
+		return types.NewNotFoundStatus(providerID), nil
 	}
 
 	instance := resp.Reservations[0].Instances[0]
-	state := *instance.State.Name
+	state := aws.StringValue(instance.State.Name)
 
 	klog.Infof("Instance %s state: %s", instanceID, state)
 
-	return state != ec2.InstanceStateNameTerminated && state != ec2.InstanceStateNameShuttingDown, nil
+	return &types.InstanceStatus{
+		State:         instanceState(state),
+		ProviderState: state,
+		LaunchTime:    instance.LaunchTime,
+		ProviderID:    providerID,
+	}, nil
+}
+
+// GetInstanceStatuses look up the instances of each region in batches of maxBatchSize, filtering on the instance ids
+// so a missing instance doesn't fail the whole call, the instances absent from the response are not found
+func (a *Aws) GetInstanceStatuses(ctx context.Context, nodes []*v1.Node) types.InstanceResults {
+	results := types.InstanceResults{}
+	providerIDs := map[string]string{}   // instance id -> providerID
+	instanceIDs := map[string][]string{} // region -> instance ids
+	for _, node := range nodes {
+		region, instanceID, err := a.locate(node)
+		if err != nil {
+			results[node.Spec.ProviderID] = types.InstanceResult{Err: err}
+			continue
+		}
+		if _, ok := providerIDs[instanceID]; !ok {
+			instanceIDs[region] = append(instanceIDs[region], instanceID)
+		}
+		providerIDs[instanceID] = node.Spec.ProviderID
+	}
+	for region, ids := range instanceIDs {
+		svc, err := a.client(region)
+		if err != nil {
+			for _, instanceID := range ids {
+				results[providerIDs[instanceID]] = types.InstanceResult{Err: err}
+			}
+			continue
+		}
+		for len(ids) > 0 {
+			chunk := ids[:min(len(ids), maxBatchSize)]
+			ids = ids[len(chunk):]
+			a.describeBatch(ctx, svc, chunk, providerIDs, results)
+		}
+	}
+	return results
+}
+
+// describeBatch describe one batch of instances and fill their results
+func (a *Aws) describeBatch(ctx context.Context, svc *ec2.EC2, instanceIDs []string, providerIDs map[string]string, results types.InstanceResults) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice(instanceIDs)}},
+	}
+	found := map[string]bool{}
+	start := time.Now()
+	err := svc.DescribeInstancesPagesWithContext(ctx, input, func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				instanceID := aws.StringValue(instance.InstanceId)
+				providerID, ok := providerIDs[instanceID]
+				if !ok {
+					continue
+				}
+				found[instanceID] = true
+				state := ""
+				if instance.State != nil {
+					state = aws.StringValue(instance.State.Name)
+				}
+				results[providerID] = types.InstanceResult{Status: &types.InstanceStatus{
+					State:         instanceState(state),
+					ProviderState: state,
+					LaunchTime:    instance.LaunchTime,
+					ProviderID:    providerID,
+				}}
+			}
+		}
+		return true
+	})
+	metrics.ObserveProviderCall("aws", "DescribeInstances", start, err)
+	if err != nil {
+		err = classifyError(err)
+		klog.Errorf("Failed to describe %d instances: %v", len(instanceIDs), err)
+		for _, instanceID := range instanceIDs {
+			results[providerIDs[instanceID]] = types.InstanceResult{Err: err}
+		}
+		return
+	}
+	for _, instanceID := range instanceIDs {
+		if !found[instanceID] {
+			klog.Infof("Instance %s not found.", instanceID)
+			results[providerIDs[instanceID]] = types.InstanceResult{Status: types.NewNotFoundStatus(providerIDs[instanceID])}
+		}
+	}
+}
+
+// instanceState map EC2 instance state names to instance states
+func instanceState(state string) types.InstanceState {
+	switch state {
+	case ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning:
+		return types.InstanceRunning
+	case ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped:
+		return types.InstanceStopped
+	case ec2.InstanceStateNameShuttingDown:
+		return types.InstanceTerminating
+	case ec2.InstanceStateNameTerminated:
+		return types.InstanceTerminated
+	default:
+		return types.InstanceUnknown
+	}
+}
+
+// authErrorCodes EC2, STS and SDK error codes of missing, invalid, expired or unauthorized credentials
+var authErrorCodes = map[string]bool{
+	"AuthFailure":           true,
+	"UnauthorizedOperation": true,
+	"InvalidClientTokenId":  true,
+	"SignatureDoesNotMatch": true,
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+	"AccessDenied":          true,
+	"OptInRequired":         true,
+	"NoCredentialProviders": true,
+}
+
+// classifyError map an EC2 error into the provider error taxonomy, the unrecognized errors are returned as is
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch code := awsErr.Code(); {
+		case code == errCodeInstanceNotFound:
+			return types.NewCloudError("aws", types.ErrorNotFound, err)
+		case code == errCodeInstanceMalformed:
+			// retrying won't fix the id, and an invalid id doesn't tell the instance is gone
+			return types.NewCloudError("aws", types.ErrorPermanent, err)
+		case authErrorCodes[code]:
+			return types.NewCloudError("aws", types.ErrorAuthFailure, err)
+		case request.IsErrorThrottle(awsErr):
+			return types.NewThrottledError("aws", 0, err)
+		case code == request.ErrCodeRequestError || code == request.ErrCodeResponseTimeout || code == request.CanceledErrorCode:
+			return types.NewCloudError("aws", types.ErrorTransient, err)
+		}
+	} else if strings.Contains(err.Error(), errCodeInstanceNotFound) {
+		return types.NewCloudError("aws", types.ErrorNotFound, err)
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		if reqErr.StatusCode() >= 500 {
+			return types.NewCloudError("aws", types.ErrorTransient, err)
+		}
+		if reqErr.StatusCode() >= 400 {
+			return types.NewCloudError("aws", types.ErrorPermanent, err)
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return types.NewCloudError("aws", types.ErrorTransient, err)
+	}
+	return err
 }
@@ -1,10 +1,24 @@
 package aws
 
 import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/google/uuid"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestAwsCheckNode(t *testing.T) {
@@ -16,12 +30,117 @@ func TestAwsCheckNode(t *testing.T) {
 			ProviderID: "aws:///us-west-2/bbb",
 		},
 	}
+	config.Options = &option.Options{AWS: option.ProviderOptions{Region: "us-west-2"}}
 	api, err := InitAwsCloudProvider()
 	if err != nil {
 		return
 	}
-	_, err = api.CheckNodeInstanceExists(node)
+	_, err = api.GetInstanceStatus(context.Background(), node)
 	if err != nil {
 		return
 	}
 }
+
+func TestClientCachedPerRegion(t *testing.T) {
+	o := option.ProviderOptions{Region: "us-west-2"}
+	sess, err := newSession(o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	api := newAws(o, sess)
+	west, err := api.client("us-west-2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again, _ := api.client("us-west-2"); again != west {
+		t.Errorf("expected the client of a region to be reused")
+	}
+	east, err := api.client("us-east-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if east == west || aws.StringValue(east.Config.Region) != "us-east-1" {
+		t.Errorf("expected a dedicated client for us-east-1, got region %s", aws.StringValue(east.Config.Region))
+	}
+}
+
+func TestLocateRegionFromProviderID(t *testing.T) {
+	config.Options = &option.Options{AWS: option.ProviderOptions{Region: "us-west-2", AllowedRegions: []string{"us-west-2", "us-east-1"}}}
+	api := newAws(config.Options.AWS, nil)
+	cases := []struct {
+		providerID string
+		region     string
+		wantErr    bool
+	}{
+		{providerID: "aws:///us-east-1a/i-1", region: "us-east-1"},
+		{providerID: "aws:///us-west-2-lax-1a/i-2", region: "us-west-2"},
+		{providerID: "aws:///eu-west-1b/i-3", wantErr: true},
+		{providerID: "aws:///unknown/i-4", wantErr: true},
+	}
+	for _, tc := range cases {
+		region, _, err := api.locate(&v1.Node{Spec: v1.NodeSpec{ProviderID: tc.providerID}})
+		if tc.wantErr {
+			if types.KindOf(err) != types.ErrorPermanent {
+				t.Errorf("%s: expected a permanent error, got region %s, %v", tc.providerID, region, err)
+			}
+			continue
+		}
+		if err != nil || region != tc.region {
+			t.Errorf("%s: expected region %s, got %s, %v", tc.providerID, tc.region, region, err)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		kind types.ErrorKind
+	}{
+		{name: "not found", err: awserr.New("InvalidInstanceID.NotFound", "missing", nil), kind: types.ErrorNotFound},
+		{name: "throttled", err: awserr.New("RequestLimitExceeded", "slow down", nil), kind: types.ErrorThrottled},
+		{name: "auth failure", err: awserr.New("AuthFailure", "bad keys", nil), kind: types.ErrorAuthFailure},
+		{name: "network", err: awserr.New(request.ErrCodeRequestError, "send request failed", nil), kind: types.ErrorTransient},
+		{name: "server error", err: awserr.NewRequestFailure(awserr.New("Unavailable", "down", nil), 503, "id"), kind: types.ErrorTransient},
+		{name: "invalid request", err: awserr.NewRequestFailure(awserr.New("InvalidInstanceID.Malformed", "bad id", nil), 400, "id"), kind: types.ErrorPermanent},
+		{name: "malformed id", err: awserr.New("InvalidInstanceID.Malformed", "bad id", nil), kind: types.ErrorPermanent},
+		{name: "unknown", err: errors.New("boom"), kind: types.ErrorUnknown},
+	}
+	for _, tc := range cases {
+		if got := types.KindOf(classifyError(tc.err)); got != tc.kind {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.kind, got)
+		}
+	}
+}
+
+func TestCheckCredential(t *testing.T) {
+	var rejected atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "DescribeInstances" || r.Form.Get("DryRun") != "true" {
+			t.Errorf("expected a dry run DescribeInstances, got %v", r.Form)
+		}
+		code, status := "DryRunOperation", http.StatusPreconditionFailed
+		if rejected.Load() {
+			code, status = "UnauthorizedOperation", http.StatusForbidden
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`<Response><Errors><Error><Code>` + code + `</Code><Message>dry run</Message></Error></Errors>` +
+			`<RequestID>1</RequestID></Response>`))
+	}))
+	defer server.Close()
+	sess, err := session.NewSession(aws.NewConfig().WithRegion("us-west-2").WithEndpoint(server.URL).
+		WithCredentials(credentials.NewStaticCredentials("AKIA", "s3cr3t", "")).WithMaxRetries(0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client, _ := newAws(option.ProviderOptions{Region: "us-west-2"}, sess).client("us-west-2")
+
+	if err := checkCredential(client, 5*time.Second); err != nil {
+		t.Errorf("expected valid credentials, got %v", err)
+	}
+	rejected.Store(true)
+	if err := checkCredential(client, 5*time.Second); err == nil {
+		t.Errorf("expected rejected credentials to fail the check")
+	}
+}
@@ -2,13 +2,20 @@ package azure
 
 import (
 	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/metrics"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
@@ -17,82 +24,467 @@ import (
 )
 
 // Azure is a provider that checks for VM existence in Azure.
+// The subscription of each VM is read from the providerID of its node, the clients of every subscription share one credential
 type Azure struct {
-	vmClient *armcompute.VirtualMachinesClient
+	cred                 azcore.TokenCredential
+	clientOptions        *arm.ClientOptions
+	allowedSubscriptions map[string]bool // lowercase subscription ids, empty allows any subscription
+
+	mu      sync.Mutex
+	clients map[string]*subscriptionClients // keyed by lowercase subscription id
+}
+
+// subscriptionClients compute clients of one subscription
+type subscriptionClients struct {
+	vm     *armcompute.VirtualMachinesClient
+	vmssVM *armcompute.VirtualMachineScaleSetVMsClient
 }
 
-// NewProvider creates a new Azure provider using Managed Identity to authenticate.
-// It acquires credentials via DefaultAzureCredential, which supports Managed Identity.
+// InitAzureProvider creates a new Azure provider for the selected cloud and credential type,
+// DefaultAzureCredential by default, which will use Managed Identity if available.
+// The credential is checked with a token fetch so a misconfiguration fails at startup
 func InitAzureProvider() (*Azure, error) {
-	// Use DefaultAzureCredential, which will use Managed Identity if available
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	cloudConfig, err := cloudConfiguration(config.Options.Azure)
+	if err != nil {
+		return nil, err
+	}
+	cred, err := newCredential(config.Options.Azure, cloudConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to acquire Azure credential: %w", err)
 	}
-	// Create the VM client
-	client, err := armcompute.NewVirtualMachinesClient(config.Options.SubscriptionID, cred, nil)
+	if err := checkCredential(cred, cloudConfig); err != nil {
+		return nil, fmt.Errorf("invalid Azure credential: %w", err)
+	}
+	a := &Azure{
+		cred:                 cred,
+		clientOptions:        armClientOptions(cloudConfig),
+		allowedSubscriptions: map[string]bool{},
+		clients:              map[string]*subscriptionClients{},
+	}
+	for _, subscriptionID := range config.Options.Azure.Subscriptions() {
+		a.allowedSubscriptions[strings.ToLower(subscriptionID)] = true
+	}
+	return a, nil
+}
+
+// clientsFor return the clients of the subscription of the VM, created on first use and cached
+func (a *Azure) clientsFor(ref *vmRef) (*subscriptionClients, error) {
+	key := strings.ToLower(ref.SubscriptionID)
+	if len(a.allowedSubscriptions) > 0 && !a.allowedSubscriptions[key] {
+		return nil, fmt.Errorf("subscription %s is not allowed, allowed subscriptions: %s",
+			ref.SubscriptionID, strings.Join(config.Options.Azure.Subscriptions(), ","))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if clients, ok := a.clients[key]; ok {
+		return clients, nil
+	}
+	vm, err := armcompute.NewVirtualMachinesClient(ref.SubscriptionID, a.cred, a.clientOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create VirtualMachinesClient: %w", err)
 	}
-	return &Azure{client}, nil
+	vmssVM, err := armcompute.NewVirtualMachineScaleSetVMsClient(ref.SubscriptionID, a.cred, a.clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VirtualMachineScaleSetVMsClient: %w", err)
+	}
+	clients := &subscriptionClients{vm: vm, vmssVM: vmssVM}
+	a.clients[key] = clients
+	return clients, nil
+}
+
+// vmRef the Azure VM backing a node
+type vmRef struct {
+	SubscriptionID string
+	ResourceGroup  string
+	ScaleSet       string // uniform scale set of the VM, empty for standalone VMs and VMs of flexible scale sets
+	Name           string // VM name, instance id for the VMs of a uniform scale set
 }
 
-// parseInstanceFromProviderID parse resource group and VM name from provider id
-func parseInstanceFromProviderID(node *corev1.Node) (string, string, error) {
-	// providerid azure:///subscriptions/sub123/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm-01
+// parseInstanceFromProviderID parse the VM of the node from its provider id, the segment names are case-insensitive:
+//
+//	standalone VM or flexible scale set VM: azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachines/<vm>
+//	uniform scale set VM: azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachineScaleSets/<vmss>/virtualMachines/<instance id>
+//
+// a flexible scale set VM referenced under its scale set, with its VM name instead of a numeric instance id, is a standalone VM
+func parseInstanceFromProviderID(node *corev1.Node) (*vmRef, error) {
 	providerID := node.Spec.ProviderID
 	if !strings.HasPrefix(providerID, "azure://") {
-		return "", "", fmt.Errorf("invalid providerID: %s", providerID)
+		return nil, fmt.Errorf("invalid providerID: %s", providerID)
 	}
 
-	// split providerid
-	path := strings.TrimPrefix(providerID, "azure://")
-	// parts: ["subscriptions","<sub>","resourceGroups","<rg>","providers","Microsoft.Compute","virtualMachines","<vm>"]
-	parts := strings.Split(path, "/")
-
-	// find "resourceGroups" and "virtualMachines" positions
-	if len(parts) == 8 && parts[2] == "resourceGroups" && parts[7] == "virtualMachines" {
-		resourceGroup := parts[3]
-		vmName := parts[7]
-		return resourceGroup, vmName, nil
+	// parts: ["subscriptions","<sub>","resourceGroups","<rg>","providers","Microsoft.Compute", ...]
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(providerID, "azure://"), "/"), "/")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid providerID format: %s", providerID)
+		}
+	}
+	if len(parts) < 8 || !strings.EqualFold(parts[0], "subscriptions") || !strings.EqualFold(parts[2], "resourceGroups") ||
+		!strings.EqualFold(parts[4], "providers") || !strings.EqualFold(parts[5], "Microsoft.Compute") {
+		return nil, fmt.Errorf("invalid providerID format: %s", providerID)
 	}
+	ref := &vmRef{SubscriptionID: parts[1], ResourceGroup: parts[3]}
+	resource := parts[6:]
+	switch {
+	case len(resource) == 2 && strings.EqualFold(resource[0], "virtualMachines"):
+		ref.Name = resource[1]
+	case len(resource) == 4 && strings.EqualFold(resource[0], "virtualMachineScaleSets") && strings.EqualFold(resource[2], "virtualMachines"):
+		if isInstanceID(resource[3]) {
+			ref.ScaleSet = resource[1]
+		}
+		ref.Name = resource[3]
+	default:
+		return nil, fmt.Errorf("invalid providerID format: %s", providerID)
+	}
+	return ref, nil
+}
 
-	return "", "", fmt.Errorf("invalid providerID format: %s", providerID)
+// isInstanceID whether the VM name is the numeric instance id of a uniform scale set VM
+func isInstanceID(name string) bool {
+	_, err := strconv.ParseUint(name, 10, 64)
+	return err == nil
 }
 
-// CheckNodeInstanceExists check if the Azure VM instance exists
-func (a *Azure) CheckNodeInstanceExists(node *corev1.Node) (bool, error) {
-	resourceGroup, vmName, err := parseInstanceFromProviderID(node)
+// GetInstanceStatus get the status of the Azure VM backing the node
+func (a *Azure) GetInstanceStatus(ctx context.Context, node *corev1.Node) (*types.InstanceStatus, error) {
+	ref, err := parseInstanceFromProviderID(node)
 	if err != nil {
 		klog.Errorf("Failed to parse instance ID from provider ID %s: %v", node.Spec.ProviderID, err)
-		return false, err
+		return nil, err
+	}
+	clients, err := a.clientsFor(ref)
+	if err != nil {
+		return nil, err
+	}
+	if ref.ScaleSet != "" {
+		return getScaleSetVMStatus(ctx, clients, node.Spec.ProviderID, ref)
 	}
 
-	ctx := context.Background()
 	opts := &armcompute.VirtualMachinesClientGetOptions{
 		Expand: to.Ptr(armcompute.InstanceViewTypesInstanceView),
 	}
-	_, err = a.vmClient.Get(ctx, resourceGroup, vmName, opts)
+	start := time.Now()
+	resp, err := clients.vm.Get(ctx, ref.ResourceGroup, ref.Name, opts)
+	metrics.ObserveProviderCall("azure", "VirtualMachines.Get", start, err)
 	if err != nil {
-		if isNotFoundError(err) {
-			klog.Infof("Instance %s not found, has been released.", vmName)
-			return false, nil
+		err = classifyError(err, ref.SubscriptionID)
+		if types.IsNotFound(err) {
+			klog.Infof("Instance %s not found, has been released.", ref.Name)
+			return types.NewNotFoundStatus(node.Spec.ProviderID), nil
 		}
-		klog.Errorf("Failed to get VM %s: %v", vmName, err)
-		return true, err
+		klog.Errorf("Failed to get VM %s: %v", ref.Name, err)
+		return nil, err
 	}
-	return true, nil
+	return vmStatus(node.Spec.ProviderID, &resp.VirtualMachine), nil
 }
 
-// isNotFoundError returns true if the error is a 404 Not Found from Azure.
-func isNotFoundError(err error) bool {
+// vmStatus status of a standalone VM from its model
+func vmStatus(providerID string, vm *armcompute.VirtualMachine) *types.InstanceStatus {
+	props := vm.Properties
+	if props == nil {
+		return newInstanceStatus(providerID, nil, nil, nil)
+	}
+	var statuses []*armcompute.InstanceViewStatus
+	if props.InstanceView != nil {
+		statuses = props.InstanceView.Statuses
+	}
+	return newInstanceStatus(providerID, props.TimeCreated, props.ProvisioningState, statuses)
+}
+
+// getScaleSetVMStatus get the status of a VM of a uniform scale set
+func getScaleSetVMStatus(ctx context.Context, clients *subscriptionClients, providerID string, ref *vmRef) (*types.InstanceStatus, error) {
+	opts := &armcompute.VirtualMachineScaleSetVMsClientGetOptions{
+		Expand: to.Ptr(armcompute.InstanceViewTypesInstanceView),
+	}
+	start := time.Now()
+	resp, err := clients.vmssVM.Get(ctx, ref.ResourceGroup, ref.ScaleSet, ref.Name, opts)
+	metrics.ObserveProviderCall("azure", "VirtualMachineScaleSetVMs.Get", start, err)
+	if err != nil {
+		err = classifyError(err, ref.SubscriptionID)
+		if types.IsNotFound(err) {
+			klog.Infof("Instance %s of scale set %s not found, has been released.", ref.Name, ref.ScaleSet)
+			return types.NewNotFoundStatus(providerID), nil
+		}
+		klog.Errorf("Failed to get VM %s of scale set %s: %v", ref.Name, ref.ScaleSet, err)
+		return nil, err
+	}
+	return scaleSetVMStatus(providerID, &resp.VirtualMachineScaleSetVM), nil
+}
+
+// scaleSetVMStatus status of a VM of a uniform scale set from its model
+func scaleSetVMStatus(providerID string, vm *armcompute.VirtualMachineScaleSetVM) *types.InstanceStatus {
+	props := vm.Properties
+	if props == nil {
+		return newInstanceStatus(providerID, nil, nil, nil)
+	}
+	var statuses []*armcompute.InstanceViewStatus
+	if props.InstanceView != nil {
+		statuses = props.InstanceView.Statuses
+	}
+	return newInstanceStatus(providerID, props.TimeCreated, props.ProvisioningState, statuses)
+}
+
+// newInstanceStatus status of a VM from its provisioning state and the statuses of its instance view.
+// A VM being deleted is terminating whatever its power state, a VM whose provisioning failed keeps the state
+// of its power state, unknown without one. A VM without power state is running
+func newInstanceStatus(providerID string, timeCreated *time.Time, provisioningState *string, statuses []*armcompute.InstanceViewStatus) *types.InstanceStatus {
+	status := &types.InstanceStatus{
+		State:      types.InstanceRunning,
+		ProviderID: providerID,
+		LaunchTime: timeCreated,
+	}
+	powerState := powerStateOf(statuses)
+	if powerState != "" {
+		status.ProviderState = powerState
+		status.State = instanceState(powerState)
+	}
+	provisioning := provisioningStateOf(statuses)
+	if provisioning == "" && provisioningState != nil {
+		provisioning = "ProvisioningState/" + strings.ToLower(*provisioningState)
+	}
+	switch provisioningPhase(provisioning) {
+	case "deleting":
+		status.State = types.InstanceTerminating
+		status.ProviderState = provisioning
+	case "failed":
+		if powerState == "" {
+			status.State = types.InstanceUnknown
+			status.ProviderState = provisioning
+		} else {
+			status.ProviderState = provisioning + ", " + powerState
+		}
+	}
+	return status
+}
+
+// GetInstanceStatuses look up the nodes of each subscription or uniform scale set holding several nodes in one list call,
+// the VMs missing from the list are not found. The list of the standalone VMs of a subscription returns their run time status,
+// the list of a uniform scale set returns the instance views
+func (a *Azure) GetInstanceStatuses(ctx context.Context, nodes []*corev1.Node) types.InstanceResults {
+	results := types.InstanceResults{}
+	type group struct {
+		ref   *vmRef                  // subscription, and resource group and scale set of the scale set VMs
+		nodes map[string]*corev1.Node // keyed by lowercase <resource group>/<name> for the standalone VMs, instance id otherwise
+	}
+	groups := map[string]*group{}
+	for _, node := range nodes {
+		ref, err := parseInstanceFromProviderID(node)
+		if err != nil {
+			results[node.Spec.ProviderID] = types.InstanceResult{Err: err}
+			continue
+		}
+		key, name := ref.SubscriptionID, ref.ResourceGroup+"/"+ref.Name
+		if ref.ScaleSet != "" {
+			key, name = ref.SubscriptionID+"/"+ref.ResourceGroup+"/"+ref.ScaleSet, ref.Name
+		}
+		key = strings.ToLower(key)
+		if groups[key] == nil {
+			groups[key] = &group{ref: ref, nodes: map[string]*corev1.Node{}}
+		}
+		groups[key].nodes[strings.ToLower(name)] = node
+	}
+	for _, g := range groups {
+		if len(g.nodes) == 1 {
+			for _, node := range g.nodes {
+				status, err := a.GetInstanceStatus(ctx, node)
+				results[node.Spec.ProviderID] = types.InstanceResult{Status: status, Err: err}
+			}
+			continue
+		}
+		clients, err := a.clientsFor(g.ref)
+		if err != nil {
+			for _, node := range g.nodes {
+				results[node.Spec.ProviderID] = types.InstanceResult{Err: err}
+			}
+			continue
+		}
+		if g.ref.ScaleSet != "" {
+			listScaleSetVMs(ctx, clients, g.ref, g.nodes, results)
+		} else {
+			listVMStatuses(ctx, clients, g.ref, g.nodes, results)
+		}
+	}
+	return results
+}
+
+// listVMStatuses list the run time status of the VMs of the subscription and fill the results of its standalone nodes,
+// keyed by lowercase <resource group>/<name>
+func listVMStatuses(ctx context.Context, clients *subscriptionClients, ref *vmRef, nodes map[string]*corev1.Node, results types.InstanceResults) {
+	found := map[string]bool{}
+	pager := clients.vm.NewListAllPager(&armcompute.VirtualMachinesClientListAllOptions{StatusOnly: to.Ptr("true")})
+	for pager.More() {
+		start := time.Now()
+		page, err := pager.NextPage(ctx)
+		metrics.ObserveProviderCall("azure", "VirtualMachines.ListAll", start, err)
+		if err != nil {
+			err = classifyError(err, ref.SubscriptionID)
+			klog.Errorf("Failed to list VMs of subscription %s: %v", ref.SubscriptionID, err)
+			for _, node := range nodes {
+				results[node.Spec.ProviderID] = types.InstanceResult{Err: err}
+			}
+			return
+		}
+		for _, vm := range page.Value {
+			if vm == nil || vm.ID == nil {
+				continue
+			}
+			id, err := arm.ParseResourceID(*vm.ID)
+			if err != nil {
+				continue
+			}
+			name := strings.ToLower(id.ResourceGroupName + "/" + id.Name)
+			node, ok := nodes[name]
+			if !ok {
+				continue
+			}
+			found[name] = true
+			results[node.Spec.ProviderID] = types.InstanceResult{Status: vmStatus(node.Spec.ProviderID, vm)}
+		}
+	}
+	for name, node := range nodes {
+		if !found[name] {
+			klog.Infof("Instance %s not found, has been released.", name)
+			results[node.Spec.ProviderID] = types.InstanceResult{Status: types.NewNotFoundStatus(node.Spec.ProviderID)}
+		}
+	}
+}
+
+// listScaleSetVMs list the VMs of a uniform scale set with their instance view and fill the results of its nodes,
+// keyed by lowercase instance id, an unknown scale set has no VM
+func listScaleSetVMs(ctx context.Context, clients *subscriptionClients, ref *vmRef, nodes map[string]*corev1.Node, results types.InstanceResults) {
+	opts := &armcompute.VirtualMachineScaleSetVMsClientListOptions{
+		Expand: to.Ptr(string(armcompute.InstanceViewTypesInstanceView)),
+	}
+	found := map[string]bool{}
+	pager := clients.vmssVM.NewListPager(ref.ResourceGroup, ref.ScaleSet, opts)
+	for pager.More() {
+		start := time.Now()
+		page, err := pager.NextPage(ctx)
+		metrics.ObserveProviderCall("azure", "VirtualMachineScaleSetVMs.List", start, err)
+		if err != nil {
+			if err = classifyError(err, ref.SubscriptionID); types.IsNotFound(err) {
+				break
+			}
+			klog.Errorf("Failed to list VMs of scale set %s: %v", ref.ScaleSet, err)
+			for _, node := range nodes {
+				results[node.Spec.ProviderID] = types.InstanceResult{Err: err}
+			}
+			return
+		}
+		for _, vm := range page.Value {
+			if vm == nil || vm.InstanceID == nil {
+				continue
+			}
+			node, ok := nodes[strings.ToLower(*vm.InstanceID)]
+			if !ok {
+				continue
+			}
+			found[strings.ToLower(*vm.InstanceID)] = true
+			results[node.Spec.ProviderID] = types.InstanceResult{Status: scaleSetVMStatus(node.Spec.ProviderID, vm)}
+		}
+	}
+	for instanceID, node := range nodes {
+		if !found[instanceID] {
+			klog.Infof("Instance %s of scale set %s not found, has been released.", instanceID, ref.ScaleSet)
+			results[node.Spec.ProviderID] = types.InstanceResult{Status: types.NewNotFoundStatus(node.Spec.ProviderID)}
+		}
+	}
+}
+
+// powerStateOf find the PowerState/<state> status code in the statuses of an instance view
+func powerStateOf(statuses []*armcompute.InstanceViewStatus) string {
+	for _, status := range statuses {
+		if status != nil && status.Code != nil && strings.HasPrefix(*status.Code, "PowerState/") {
+			return *status.Code
+		}
+	}
+	return ""
+}
+
+// provisioningStateOf find the ProvisioningState/<state>[/<detail>] status code in the statuses of an instance view
+func provisioningStateOf(statuses []*armcompute.InstanceViewStatus) string {
+	for _, status := range statuses {
+		if status != nil && status.Code != nil && strings.HasPrefix(*status.Code, "ProvisioningState/") {
+			return *status.Code
+		}
+	}
+	return ""
+}
+
+// provisioningPhase lowercase state of a ProvisioningState/<state>[/<detail>] status code, e.g. failed of ProvisioningState/failed/AllocationFailed
+func provisioningPhase(provisioningState string) string {
+	phase, _, _ := strings.Cut(strings.TrimPrefix(provisioningState, "ProvisioningState/"), "/")
+	return strings.ToLower(phase)
+}
+
+// instanceState map VM power states to instance states
+func instanceState(powerState string) types.InstanceState {
+	switch strings.ToLower(strings.TrimPrefix(powerState, "PowerState/")) {
+	case "running", "starting":
+		return types.InstanceRunning
+	case "stopping", "stopped", "deallocating", "deallocated":
+		return types.InstanceStopped
+	default:
+		return types.InstanceUnknown
+	}
+}
+
+// subscriptionAccessErrorCodes ARM error codes telling the identity of the controller can't read the subscription,
+// a 404 SubscriptionNotFound says nothing about the VM
+var subscriptionAccessErrorCodes = map[string]bool{
+	"SubscriptionNotFound":             true,
+	"AuthorizationFailed":              true,
+	"LinkedAuthorizationFailed":        true,
+	"InvalidAuthenticationTokenTenant": true,
+}
+
+// subscriptionAccessError a clear error if the call failed because the controller has no access to the subscription, nil otherwise
+func subscriptionAccessError(err error, subscriptionID string) error {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return nil
+	}
+	if subscriptionAccessErrorCodes[respErr.ErrorCode] || respErr.StatusCode == http.StatusForbidden || respErr.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("no access to subscription %s (%s), grant the controller identity read access to its VMs: %w",
+			subscriptionID, respErr.ErrorCode, err)
+	}
+	return nil
+}
+
+// classifyError map an ARM error into the provider error taxonomy, the missing access to the subscription is an
+// auth failure and never means the VM is gone, the unrecognized errors are returned as is
+func classifyError(err error, subscriptionID string) error {
 	if err == nil {
-		return false
+		return nil
+	}
+	if accessErr := subscriptionAccessError(err, subscriptionID); accessErr != nil {
+		return types.NewCloudError("azure", types.ErrorAuthFailure, accessErr)
+	}
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		return types.NewCloudError("azure", types.ErrorAuthFailure, err)
 	}
 	// SDK returns *azcore.ResponseError for HTTP errors
 	var respErr *azcore.ResponseError
-	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
-		return true
+	if errors.As(err, &respErr) {
+		switch status := respErr.StatusCode; {
+		case status == http.StatusNotFound:
+			return types.NewCloudError("azure", types.ErrorNotFound, err)
+		case status == http.StatusTooManyRequests:
+			var retryAfter time.Duration
+			if respErr.RawResponse != nil {
+				retryAfter = types.ParseRetryAfter(respErr.RawResponse.Header.Get("Retry-After"))
+			}
+			return types.NewThrottledError("azure", retryAfter, err)
+		case status >= http.StatusInternalServerError, status == http.StatusRequestTimeout:
+			return types.NewCloudError("azure", types.ErrorTransient, err)
+		case status >= http.StatusBadRequest:
+			return types.NewCloudError("azure", types.ErrorPermanent, err)
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return types.NewCloudError("azure", types.ErrorTransient, err)
 	}
-	return false
+	return err
 }
@@ -1,63 +1,288 @@
 package azure
 
 import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	corev1 "k8s.io/api/core/v1"
 )
 
 // --- Tests for parseInstanceFromProviderID ---
 
-func TestParseInstanceFromProviderID_Success(t *testing.T) {
-	// 典型 Azure providerID，去掉多余的 '/'
+func TestParseInstanceFromProviderID(t *testing.T) {
+	cases := []struct {
+		name       string
+		providerID string
+		expected   *vmRef
+	}{
+		{
+			name:       "standalone VM",
+			providerID: "azure:///subscriptions/sub123/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm-01",
+			expected:   &vmRef{SubscriptionID: "sub123", ResourceGroup: "rg1", Name: "vm-01"},
+		},
+		{
+			// 大小写不敏感
+			name:       "lowercase segments",
+			providerID: "azure:///subscriptions/sub123/resourcegroups/rg1/providers/microsoft.compute/virtualmachines/vm-01",
+			expected:   &vmRef{SubscriptionID: "sub123", ResourceGroup: "rg1", Name: "vm-01"},
+		},
+		{
+			name:       "uniform scale set VM",
+			providerID: "azure:///subscriptions/sub123/resourceGroups/MC_rg_aks_eastus/providers/Microsoft.Compute/virtualMachineScaleSets/aks-nodepool1-123-vmss/virtualMachines/3",
+			expected:   &vmRef{SubscriptionID: "sub123", ResourceGroup: "MC_rg_aks_eastus", ScaleSet: "aks-nodepool1-123-vmss", Name: "3"},
+		},
+		{
+			name:       "flexible scale set VM",
+			providerID: "azure:///subscriptions/sub123/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/aks-flex-12345678-vmss000001",
+			expected:   &vmRef{SubscriptionID: "sub123", ResourceGroup: "rg1", Name: "aks-flex-12345678-vmss000001"},
+		},
+		{
+			name:       "flexible scale set VM under its scale set",
+			providerID: "azure:///subscriptions/sub123/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachineScaleSets/flex/virtualMachines/flex_4f2a1b3c",
+			expected:   &vmRef{SubscriptionID: "sub123", ResourceGroup: "rg1", Name: "flex_4f2a1b3c"},
+		},
+		{name: "invalid prefix", providerID: "aws:///subscriptions/xxx"},
+		// 缺少必要字段
+		{name: "missing VM name", providerID: "azure:///subscriptions/sub123/resourceGroups/rg1/virtualMachines"},
+		{name: "missing instance id", providerID: "azure:///subscriptions/sub123/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines"},
+		{name: "empty segment", providerID: "azure:///subscriptions//resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm-01"},
+		{name: "other resource type", providerID: "azure:///subscriptions/sub123/resourceGroups/rg1/providers/Microsoft.Network/networkInterfaces/nic-01"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &corev1.Node{}
+			node.Spec.ProviderID = tc.providerID
+
+			ref, err := parseInstanceFromProviderID(node)
+			if tc.expected == nil {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *ref != *tc.expected {
+				t.Errorf("expected %+v, got %+v", *tc.expected, *ref)
+			}
+		})
+	}
+}
+
+// --- Test for GetInstanceStatus error branch when parsing fails ---
+
+func TestGetInstanceStatus_ParseError(t *testing.T) {
+	a := &Azure{} // vmClient 不会被调用，因为 parseInstance 先返回错误
 	node := &corev1.Node{}
-	node.Spec.ProviderID = "azure:///subscriptions/sub123/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm-01"
+	node.Spec.ProviderID = "invalid://id"
 
-	rg, vm, err := parseInstanceFromProviderID(node)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	status, err := a.GetInstanceStatus(context.Background(), node)
+	if err == nil {
+		t.Fatal("expected parse error, got nil")
 	}
-	if rg != "rg1" {
-		t.Errorf("expected resourceGroup 'rg1', got '%s'", rg)
+	if status != nil {
+		t.Errorf("expected no status on parse error, got %+v", status)
 	}
-	if vm != "vm-01" {
-		t.Errorf("expected vmName 'vm-01', got '%s'", vm)
+}
+
+// --- Test for power state mapping ---
+
+func TestInstanceStateFromPowerState(t *testing.T) {
+	cases := map[string]types.InstanceState{
+		"PowerState/running":      types.InstanceRunning,
+		"PowerState/starting":     types.InstanceRunning,
+		"PowerState/stopped":      types.InstanceStopped,
+		"PowerState/deallocating": types.InstanceStopped,
+		"PowerState/deallocated":  types.InstanceStopped,
+		"PowerState/unknown":      types.InstanceUnknown,
+	}
+	for powerState, expected := range cases {
+		view := &armcompute.VirtualMachineInstanceView{
+			Statuses: []*armcompute.InstanceViewStatus{
+				{Code: to.Ptr("ProvisioningState/succeeded")},
+				{Code: to.Ptr(powerState)},
+			},
+		}
+		if got := instanceState(powerStateOf(view.Statuses)); got != expected {
+			t.Errorf("%s: expected %s, got %s", powerState, expected, got)
+		}
 	}
 }
 
-func TestParseInstanceFromProviderID_InvalidPrefix(t *testing.T) {
-	node := &corev1.Node{}
-	node.Spec.ProviderID = "aws:///subscriptions/xxx"
+func TestInstanceStatusFromProvisioningState(t *testing.T) {
+	cases := []struct {
+		name              string
+		provisioningState *string
+		statuses          []string
+		state             types.InstanceState
+		providerState     string
+	}{
+		{
+			name:          "deleting running VM",
+			statuses:      []string{"ProvisioningState/deleting", "PowerState/running"},
+			state:         types.InstanceTerminating,
+			providerState: "ProvisioningState/deleting",
+		},
+		{
+			name:              "deleting VM from the model",
+			provisioningState: to.Ptr("Deleting"),
+			state:             types.InstanceTerminating,
+			providerState:     "ProvisioningState/deleting",
+		},
+		{
+			name:          "failed running VM",
+			statuses:      []string{"ProvisioningState/failed/InternalOperationError", "PowerState/running"},
+			state:         types.InstanceRunning,
+			providerState: "ProvisioningState/failed/InternalOperationError, PowerState/running",
+		},
+		{
+			name:          "failed VM without power state",
+			statuses:      []string{"ProvisioningState/failed/AllocationFailed"},
+			state:         types.InstanceUnknown,
+			providerState: "ProvisioningState/failed/AllocationFailed",
+		},
+		{
+			name:          "deallocated VM",
+			statuses:      []string{"ProvisioningState/succeeded", "PowerState/deallocated"},
+			state:         types.InstanceStopped,
+			providerState: "PowerState/deallocated",
+		},
+	}
+	for _, tc := range cases {
+		var statuses []*armcompute.InstanceViewStatus
+		for _, code := range tc.statuses {
+			statuses = append(statuses, &armcompute.InstanceViewStatus{Code: to.Ptr(code)})
+		}
+		status := newInstanceStatus("azure:///vm", nil, tc.provisioningState, statuses)
+		if status.State != tc.state || status.ProviderState != tc.providerState {
+			t.Errorf("%s: expected %s (%s), got %s (%s)", tc.name, tc.state, tc.providerState, status.State, status.ProviderState)
+		}
+	}
+}
 
-	_, _, err := parseInstanceFromProviderID(node)
-	if err == nil {
-		t.Fatal("expected error for invalid prefix, got nil")
+// --- Tests for subscriptions ---
+
+func TestSubscriptionAccessError(t *testing.T) {
+	cases := []struct {
+		err    *azcore.ResponseError
+		access bool
+		kind   types.ErrorKind
+	}{
+		{err: &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "SubscriptionNotFound"}, access: true, kind: types.ErrorAuthFailure},
+		{err: &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "AuthorizationFailed"}, access: true, kind: types.ErrorAuthFailure},
+		{err: &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}, kind: types.ErrorNotFound},
+		{err: &azcore.ResponseError{StatusCode: http.StatusInternalServerError, ErrorCode: "InternalError"}, kind: types.ErrorTransient},
+		{err: &azcore.ResponseError{StatusCode: http.StatusBadRequest, ErrorCode: "InvalidParameter"}, kind: types.ErrorPermanent},
+	}
+	for _, tc := range cases {
+		if got := subscriptionAccessError(tc.err, "sub123") != nil; got != tc.access {
+			t.Errorf("%s: expected access error %v, got %v", tc.err.ErrorCode, tc.access, got)
+		}
+		if got := types.KindOf(classifyError(tc.err, "sub123")); got != tc.kind {
+			t.Errorf("%s: expected %s error, got %s", tc.err.ErrorCode, tc.kind, got)
+		}
 	}
 }
 
-func TestParseInstanceFromProviderID_BadFormat(t *testing.T) {
-	// 缺少必要字段
-	node := &corev1.Node{}
-	node.Spec.ProviderID = "azure:///subscriptions/sub123/resourceGroups/rg1/virtualMachines"
+func TestClassifyThrottling(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"17"}}}
+	err := classifyError(&azcore.ResponseError{StatusCode: http.StatusTooManyRequests, ErrorCode: "TooManyRequests", RawResponse: resp}, "sub123")
+	if types.KindOf(err) != types.ErrorThrottled {
+		t.Fatalf("expected a throttling error, got %v", err)
+	}
+	if got := types.RetryAfter(err); got != 17*time.Second {
+		t.Errorf("expected retry after 17s, got %s", got)
+	}
+}
 
-	_, _, err := parseInstanceFromProviderID(node)
-	if err == nil {
-		t.Fatal("expected error for bad format, got nil")
+func TestClientsForAllowedSubscriptions(t *testing.T) {
+	config.Options = &option.Options{Azure: option.AzureOptions{SubscriptionID: "sub123"}}
+	a := &Azure{
+		allowedSubscriptions: map[string]bool{"sub123": true},
+		clients:              map[string]*subscriptionClients{},
+	}
+	if _, err := a.clientsFor(&vmRef{SubscriptionID: "other"}); err == nil {
+		t.Error("expected error for a subscription out of the allow-list")
+	}
+	clients, err := a.clientsFor(&vmRef{SubscriptionID: "SUB123"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again, _ := a.clientsFor(&vmRef{SubscriptionID: "sub123"}); again != clients {
+		t.Error("expected the clients of a subscription to be reused")
 	}
 }
 
-// --- Test for CheckNodeInstanceExists error branch when parsing fails ---
+// staticToken credential handing out a fixed token
+type staticToken struct{}
 
-func TestCheckNodeInstanceExists_ParseError(t *testing.T) {
-	a := &Azure{} // vmClient 不会被调用，因为 parseInstance 先返回错误
-	node := &corev1.Node{}
-	node.Spec.ProviderID = "invalid://id"
+func (staticToken) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
 
-	exists, err := a.CheckNodeInstanceExists(node)
-	if err == nil {
-		t.Fatal("expected parse error, got nil")
+// transportFunc answer the requests of the Azure clients without network
+type transportFunc func(*http.Request) (*http.Response, error)
+
+func (f transportFunc) Do(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestGetInstanceStatusesListsTheSubscriptionOnce(t *testing.T) {
+	config.Options = &option.Options{}
+	var requests []string
+	transport := transportFunc(func(r *http.Request) (*http.Response, error) {
+		requests = append(requests, r.URL.Path+"?"+r.URL.RawQuery)
+		body := `{"value":[
+			{"id":"/subscriptions/sub123/resourceGroups/RG1/providers/Microsoft.Compute/virtualMachines/vm-1","name":"vm-1",
+			 "properties":{"instanceView":{"statuses":[{"code":"ProvisioningState/succeeded"},{"code":"PowerState/running"}]}}},
+			{"id":"/subscriptions/sub123/resourceGroups/rg2/providers/Microsoft.Compute/virtualMachines/vm-2","name":"vm-2",
+			 "properties":{"instanceView":{"statuses":[{"code":"PowerState/deallocated"}]}}}
+		]}`
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    r,
+		}, nil
+	})
+	a := &Azure{
+		cred:                 staticToken{},
+		clientOptions:        &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: transport}},
+		allowedSubscriptions: map[string]bool{},
+		clients:              map[string]*subscriptionClients{},
+	}
+	node := func(name, providerID string) *corev1.Node {
+		n := &corev1.Node{}
+		n.Name, n.Spec.ProviderID = name, providerID
+		return n
+	}
+	nodes := []*corev1.Node{
+		node("node-1", "azure:///subscriptions/sub123/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/vm-1"),
+		node("node-2", "azure:///subscriptions/sub123/resourceGroups/rg2/providers/Microsoft.Compute/virtualMachines/vm-2"),
+		node("node-3", "azure:///subscriptions/sub123/resourceGroups/rg2/providers/Microsoft.Compute/virtualMachines/vm-3"),
+	}
+
+	results := a.GetInstanceStatuses(context.Background(), nodes)
+	want := map[string]types.InstanceState{"node-1": types.InstanceRunning, "node-2": types.InstanceStopped, "node-3": types.InstanceNotFound}
+	for _, n := range nodes {
+		result := results[n.Spec.ProviderID]
+		if result.Err != nil || result.Status == nil || result.Status.State != want[n.Name] {
+			t.Errorf("expected %s to be %s, got %+v", n.Name, want[n.Name], result)
+		}
 	}
-	if exists {
-		t.Errorf("expected exists=false on parse error, got true")
+	if len(requests) != 1 || !strings.HasSuffix(strings.Split(requests[0], "?")[0], "/providers/Microsoft.Compute/virtualMachines") ||
+		!strings.Contains(requests[0], "statusOnly=true") {
+		t.Errorf("expected a single status only list of the subscription, got %v", requests)
 	}
 }
@@ -0,0 +1,137 @@
+package azure
+
+import (
+	"cloud-node-lifecycle-controller/pkg/option"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Azure clouds
+const (
+	CloudPublic = "public"
+	CloudChina  = "china"
+	CloudUSGov  = "usgov"
+)
+
+// Azure credential types
+const (
+	CredentialDefault           = "default"
+	CredentialManagedIdentity   = "managed-identity"
+	CredentialWorkloadIdentity  = "workload-identity"
+	CredentialClientSecret      = "client-secret"
+	CredentialClientCertificate = "client-certificate"
+)
+
+// credentialCheckTimeout how long the startup token fetch may take
+const credentialCheckTimeout = 30 * time.Second
+
+// cloudConfiguration endpoints of the selected cloud, a custom ARM endpoint overrides the cloud
+func cloudConfiguration(o option.AzureOptions) (cloud.Configuration, error) {
+	if o.ARMEndpoint != "" {
+		if o.AuthorityHost == "" {
+			return cloud.Configuration{}, fmt.Errorf("a custom ARM endpoint requires the authority host")
+		}
+		audience := o.ARMAudience
+		if audience == "" {
+			audience = strings.TrimSuffix(o.ARMEndpoint, "/")
+		}
+		return cloud.Configuration{
+			ActiveDirectoryAuthorityHost: o.AuthorityHost,
+			Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {Endpoint: o.ARMEndpoint, Audience: audience},
+			},
+		}, nil
+	}
+	switch strings.ToLower(o.Cloud) {
+	case "", CloudPublic:
+		return cloud.AzurePublic, nil
+	case CloudChina:
+		return cloud.AzureChina, nil
+	case CloudUSGov:
+		return cloud.AzureGovernment, nil
+	default:
+		return cloud.Configuration{}, fmt.Errorf("unknown azure cloud %q, supported clouds: %s, %s, %s", o.Cloud, CloudPublic, CloudChina, CloudUSGov)
+	}
+}
+
+// newCredential create the credential of the selected type against the cloud
+func newCredential(o option.AzureOptions, cloudConfig cloud.Configuration) (azcore.TokenCredential, error) {
+	clientOptions := azcore.ClientOptions{Cloud: cloudConfig}
+	switch o.CredentialType {
+	case "", CredentialDefault:
+		// DefaultAzureCredential, which will use Managed Identity if available
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      o.TenantID,
+		})
+	case CredentialManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if o.ClientID != "" {
+			options.ID = azidentity.ClientID(o.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+	case CredentialWorkloadIdentity:
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			ClientID:      o.ClientID,
+			TenantID:      o.TenantID,
+			TokenFilePath: o.TokenFile,
+		})
+	case CredentialClientSecret:
+		secret, err := os.ReadFile(o.ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client secret file: %w", err)
+		}
+		return azidentity.NewClientSecretCredential(o.TenantID, o.ClientID, strings.TrimSpace(string(secret)),
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+	case CredentialClientCertificate:
+		data, err := os.ReadFile(o.CertificateFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client certificate file: %w", err)
+		}
+		var password []byte
+		if o.CertificatePasswordFile != "" {
+			if password, err = os.ReadFile(o.CertificatePasswordFile); err != nil {
+				return nil, fmt.Errorf("read client certificate password file: %w", err)
+			}
+			password = []byte(strings.TrimSpace(string(password)))
+		}
+		certs, key, err := azidentity.ParseCertificates(data, password)
+		if err != nil {
+			return nil, fmt.Errorf("parse client certificate: %w", err)
+		}
+		return azidentity.NewClientCertificateCredential(o.TenantID, o.ClientID, certs, key,
+			&azidentity.ClientCertificateCredentialOptions{ClientOptions: clientOptions})
+	default:
+		return nil, fmt.Errorf("unknown azure credential type %q", o.CredentialType)
+	}
+}
+
+// checkCredential fetch a token for the resource manager of the cloud, so a misconfigured credential fails at startup
+// instead of on the first node check
+func checkCredential(cred azcore.TokenCredential, cloudConfig cloud.Configuration) error {
+	audience := cloudConfig.Services[cloud.ResourceManager].Audience
+	if audience == "" {
+		return fmt.Errorf("no resource manager audience for the azure cloud")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), credentialCheckTimeout)
+	defer cancel()
+	if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{strings.TrimSuffix(audience, "/") + "/.default"}}); err != nil {
+		return fmt.Errorf("fetch azure token: %w", err)
+	}
+	return nil
+}
+
+// armClientOptions options of the compute clients for the cloud
+func armClientOptions(cloudConfig cloud.Configuration) *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: cloudConfig}}
+}
@@ -0,0 +1,68 @@
+package azure
+
+import (
+	"cloud-node-lifecycle-controller/pkg/option"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+func TestCloudConfiguration(t *testing.T) {
+	cases := []struct {
+		name         string
+		options      option.AzureOptions
+		wantEndpoint string
+		wantAudience string
+		wantErr      bool
+	}{
+		{name: "default", options: option.AzureOptions{}, wantEndpoint: "https://management.azure.com"},
+		{name: "china", options: option.AzureOptions{Cloud: "China"}, wantEndpoint: "https://management.chinacloudapi.cn"},
+		{name: "us government", options: option.AzureOptions{Cloud: CloudUSGov}, wantEndpoint: "https://management.usgovcloudapi.net"},
+		{
+			name:         "custom endpoint",
+			options:      option.AzureOptions{Cloud: CloudPublic, ARMEndpoint: "https://management.local/", AuthorityHost: "https://login.local/"},
+			wantEndpoint: "https://management.local/",
+			wantAudience: "https://management.local",
+		},
+		{
+			name:         "custom audience",
+			options:      option.AzureOptions{ARMEndpoint: "https://management.local", ARMAudience: "https://management.adfs.local/", AuthorityHost: "https://login.local/"},
+			wantEndpoint: "https://management.local",
+			wantAudience: "https://management.adfs.local/",
+		},
+		{name: "custom endpoint without authority", options: option.AzureOptions{ARMEndpoint: "https://management.local"}, wantErr: true},
+		{name: "unknown cloud", options: option.AzureOptions{Cloud: "germany"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		cfg, err := cloudConfiguration(tc.options)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+		if tc.wantErr {
+			continue
+		}
+		rm := cfg.Services[cloud.ResourceManager]
+		if rm.Endpoint != tc.wantEndpoint {
+			t.Errorf("%s: expected endpoint %s, got %s", tc.name, tc.wantEndpoint, rm.Endpoint)
+		}
+		if rm.Audience == "" || (tc.wantAudience != "" && rm.Audience != tc.wantAudience) {
+			t.Errorf("%s: unexpected audience %q", tc.name, rm.Audience)
+		}
+	}
+}
+
+func TestNewCredentialErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		options option.AzureOptions
+	}{
+		{name: "unknown type", options: option.AzureOptions{CredentialType: "password"}},
+		{name: "missing secret file", options: option.AzureOptions{CredentialType: CredentialClientSecret, TenantID: "t", ClientID: "c", ClientSecretFile: "/nonexistent/secret"}},
+		{name: "missing certificate file", options: option.AzureOptions{CredentialType: CredentialClientCertificate, TenantID: "t", ClientID: "c", CertificateFile: "/nonexistent/cert.pem"}},
+	}
+	for _, tc := range cases {
+		if _, err := newCredential(tc.options, cloud.AzurePublic); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"cloud-node-lifecycle-controller/pkg/provider/aws"
 	"cloud-node-lifecycle-controller/pkg/provider/azure"
 	"cloud-node-lifecycle-controller/pkg/provider/tencentcloud"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -23,7 +25,56 @@ var DefaultInitFuncConstructors = map[string]InitCloudProvider{
 	},
 }
 
+// ProviderIDSchemes providerID scheme of the nodes managed by each cloud provider
+var ProviderIDSchemes = map[string]string{
+	"aws":     "aws",
+	"tencent": "qcloud",
+	"azure":   "azure",
+}
+
 // CloudAPI cloud provider interface
 type CloudAPI interface {
-	CheckNodeInstanceExists(node *v1.Node) (bool, error)
+	// GetInstanceStatus return the status of the instance backing the node,
+	// an instance unknown to the cloud is reported as types.InstanceNotFound rather than an error.
+	// Errors are classified with types.NewCloudError, an unclassified error never means the instance is gone.
+	// The cloud requests are cancelled with ctx
+	GetInstanceStatus(ctx context.Context, node *v1.Node) (*types.InstanceStatus, error)
+}
+
+// BatchCloudAPI cloud provider able to look up many instances in one call
+type BatchCloudAPI interface {
+	CloudAPI
+	// GetInstanceStatuses return the status of the instances backing the nodes keyed by providerID,
+	// every node gets a result, a failed lookup is reported in the result of the node
+	GetInstanceStatuses(ctx context.Context, nodes []*v1.Node) types.InstanceResults
+}
+
+// ExistenceAPI cloud provider interface that can only tell whether the instance exists
+type ExistenceAPI interface {
+	CheckNodeInstanceExists(ctx context.Context, node *v1.Node) (bool, error)
+}
+
+// FromExistenceAPI adapt a provider implementing ExistenceAPI to CloudAPI
+func FromExistenceAPI(api ExistenceAPI) CloudAPI {
+	return &existenceAdapter{api: api}
+}
+
+// existenceAdapter report existing instances as running and missing ones as not found
+type existenceAdapter struct {
+	api ExistenceAPI
+}
+
+// GetInstanceStatus get instance status from the existence check
+func (e *existenceAdapter) GetInstanceStatus(ctx context.Context, node *v1.Node) (*types.InstanceStatus, error) {
+	exists, err := e.api.CheckNodeInstanceExists(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return types.NewNotFoundStatus(node.Spec.ProviderID), nil
+	}
+	return &types.InstanceStatus{
+		State:      types.InstanceRunning,
+		ProviderID: node.Spec.ProviderID,
+	}, nil
 }
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// Router cloud provider dispatching each node to the provider matching its providerID scheme
+type Router struct {
+	providers map[string]CloudAPI // keyed by providerID scheme
+}
+
+// NewRouter init the named cloud providers and route nodes to them by providerID scheme
+func NewRouter(names []string) (*Router, error) {
+	router := &Router{providers: map[string]CloudAPI{}}
+	for _, name := range names {
+		initFunc, ok := DefaultInitFuncConstructors[name]
+		if !ok {
+			return nil, fmt.Errorf("cloud provider %s not support", name)
+		}
+		scheme, ok := ProviderIDSchemes[name]
+		if !ok {
+			return nil, fmt.Errorf("cloud provider %s has no providerID scheme", name)
+		}
+		api, err := initFunc()
+		if err != nil {
+			return nil, fmt.Errorf("init cloud provider %s: %w", name, err)
+		}
+		router.providers[scheme] = api
+	}
+	return router, nil
+}
+
+// GetInstanceStatus get the instance status from the provider matching the providerID scheme of the node
+func (r *Router) GetInstanceStatus(ctx context.Context, node *v1.Node) (*types.InstanceStatus, error) {
+	api, err := r.route(node)
+	if err != nil {
+		return nil, err
+	}
+	return api.GetInstanceStatus(ctx, node)
+}
+
+// GetInstanceStatuses group the nodes by provider and look them up in batches,
+// one node at a time for the providers without batch support
+func (r *Router) GetInstanceStatuses(ctx context.Context, nodes []*v1.Node) types.InstanceResults {
+	results := types.InstanceResults{}
+	groups := map[CloudAPI][]*v1.Node{}
+	for _, node := range nodes {
+		api, err := r.route(node)
+		if err != nil {
+			results[node.Spec.ProviderID] = types.InstanceResult{Err: err}
+			continue
+		}
+		groups[api] = append(groups[api], node)
+	}
+	for api, group := range groups {
+		if batch, ok := api.(BatchCloudAPI); ok {
+			for providerID, result := range batch.GetInstanceStatuses(ctx, group) {
+				results[providerID] = result
+			}
+			continue
+		}
+		for _, node := range group {
+			status, err := api.GetInstanceStatus(ctx, node)
+			results[node.Spec.ProviderID] = types.InstanceResult{Status: status, Err: err}
+		}
+	}
+	return results
+}
+
+func (r *Router) route(node *v1.Node) (CloudAPI, error) {
+	scheme := providerIDScheme(node.Spec.ProviderID)
+	api, ok := r.providers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: no cloud provider enabled for scheme %q of %s", types.ErrUnsupportedProviderID, scheme, node.Spec.ProviderID)
+	}
+	return api, nil
+}
+
+// providerIDScheme return the scheme of a providerID, e.g. aws for aws:///us-west-2a/i-abcd
+func providerIDScheme(providerID string) string {
+	scheme, _, found := strings.Cut(providerID, "://")
+	if !found {
+		return ""
+	}
+	return scheme
+}
+
+// NameOf name of the cloud provider whose providerID scheme matches, unknown if none does
+func NameOf(providerID string) string {
+	scheme := providerIDScheme(providerID)
+	for name, s := range ProviderIDSchemes {
+		if s == scheme {
+			return name
+		}
+	}
+	return "unknown"
+}
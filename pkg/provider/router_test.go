@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+type fakeCloudAPI struct {
+	state types.InstanceState
+}
+
+func (f *fakeCloudAPI) GetInstanceStatus(_ context.Context, node *v1.Node) (*types.InstanceStatus, error) {
+	return &types.InstanceStatus{State: f.state, ProviderID: node.Spec.ProviderID}, nil
+}
+
+func TestRouterDispatchByScheme(t *testing.T) {
+	router := &Router{providers: map[string]CloudAPI{
+		"aws":    &fakeCloudAPI{state: types.InstanceRunning},
+		"qcloud": &fakeCloudAPI{state: types.InstanceStopped},
+	}}
+
+	cases := []struct {
+		providerID  string
+		state       types.InstanceState
+		unsupported bool
+	}{
+		{providerID: "aws:///us-west-2a/i-abcd", state: types.InstanceRunning},
+		{providerID: "qcloud:///ap-singapore/ins-abcd", state: types.InstanceStopped},
+		{providerID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm", unsupported: true},
+		{providerID: "kind://docker/kind/kind-worker", unsupported: true},
+		{providerID: "i-abcd", unsupported: true},
+	}
+	for _, tc := range cases {
+		node := &v1.Node{Spec: v1.NodeSpec{ProviderID: tc.providerID}}
+		status, err := router.GetInstanceStatus(context.Background(), node)
+		if tc.unsupported {
+			if !types.IsUnsupportedProviderID(err) {
+				t.Errorf("%s: expected unsupported providerID error, got %v", tc.providerID, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.providerID, err)
+			continue
+		}
+		if status.State != tc.state {
+			t.Errorf("%s: expected state %s, got %s", tc.providerID, tc.state, status.State)
+		}
+	}
+}
+
+type fakeBatchCloudAPI struct {
+	fakeCloudAPI
+	calls int
+}
+
+func (f *fakeBatchCloudAPI) GetInstanceStatuses(_ context.Context, nodes []*v1.Node) types.InstanceResults {
+	f.calls++
+	results := types.InstanceResults{}
+	for _, node := range nodes {
+		results[node.Spec.ProviderID] = types.InstanceResult{Status: types.NewNotFoundStatus(node.Spec.ProviderID)}
+	}
+	return results
+}
+
+func TestRouterBatchByProvider(t *testing.T) {
+	batch := &fakeBatchCloudAPI{}
+	router := &Router{providers: map[string]CloudAPI{
+		"aws":    batch,
+		"qcloud": &fakeCloudAPI{state: types.InstanceStopped},
+	}}
+
+	var nodes []*v1.Node
+	for _, providerID := range []string{"aws:///us-west-2a/i-1", "aws:///us-west-2a/i-2", "qcloud:///ap-singapore/ins-1", "kind://docker/kind/kind-worker"} {
+		nodes = append(nodes, &v1.Node{Spec: v1.NodeSpec{ProviderID: providerID}})
+	}
+	results := router.GetInstanceStatuses(context.Background(), nodes)
+	if batch.calls != 1 {
+		t.Errorf("expected the aws nodes to be looked up in one call, got %d", batch.calls)
+	}
+	if len(results) != len(nodes) {
+		t.Fatalf("expected a result per node, got %d", len(results))
+	}
+	if r := results["aws:///us-west-2a/i-2"]; r.Err != nil || r.Status.State != types.InstanceNotFound {
+		t.Errorf("unexpected aws result %+v", r)
+	}
+	if r := results["qcloud:///ap-singapore/ins-1"]; r.Err != nil || r.Status.State != types.InstanceStopped {
+		t.Errorf("unexpected tencent result %+v", r)
+	}
+	if r := results["kind://docker/kind/kind-worker"]; !types.IsUnsupportedProviderID(r.Err) {
+		t.Errorf("expected unsupported providerID error, got %v", r.Err)
+	}
+}
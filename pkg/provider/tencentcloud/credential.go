@@ -0,0 +1,188 @@
+package tencentcloud
+
+import (
+	"cloud-node-lifecycle-controller/pkg/option"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	"k8s.io/klog/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// STS settings of the assumed role
+const (
+	stsEndpoint        = "sts.tencentcloudapi.com"
+	stsRegion          = "ap-guangzhou"
+	roleSessionName    = "cloud-node-lifecycle-controller"
+	roleSessionSeconds = 3600
+	// roleRefreshBefore the role credentials are refreshed this long before they expire
+	roleRefreshBefore = 5 * time.Minute
+	// stsTimeout timeout of an AssumeRole call, the API calls needing fresh credentials wait for it
+	stsTimeout = 10 * time.Second
+)
+
+// newCredential return the credential of the provider: the static keys if set, otherwise the first credential found
+// by the SDK provider chain (TENCENTCLOUD_SECRET_ID/TENCENTCLOUD_SECRET_KEY env, ~/.tencentcloud/credentials profile, CVM role).
+// With a role ARN those credentials assume the role through STS
+func newCredential(o option.ProviderOptions) (common.CredentialIface, error) {
+	var source common.CredentialIface
+	if o.AccessKeyID != "" {
+		source = common.NewCredential(o.AccessKeyID, o.SecretKeyID)
+	} else {
+		cred, err := common.DefaultProviderChain().GetCredential()
+		if err != nil {
+			return nil, fmt.Errorf("find tencent credential: %w", err)
+		}
+		source = cred
+	}
+	if o.RoleARN == "" {
+		return source, nil
+	}
+	role := newRoleCredential(source, o.RoleARN, o.ExternalID)
+	if err := role.refresh(); err != nil {
+		return nil, fmt.Errorf("assume role %s: %w", o.RoleARN, err)
+	}
+	return role, nil
+}
+
+// roleCredential temporary credentials of a CAM role assumed through STS, refreshed before they expire.
+// The SDK RoleArnProvider can't pass an external id nor use temporary source credentials, hence this implementation
+type roleCredential struct {
+	source     common.CredentialIface
+	roleARN    string
+	externalID string
+
+	keys      atomic.Pointer[roleKeys] // replaced as a whole, so the id, the key and the token always match
+	refreshMu sync.Mutex               // one AssumeRole call at a time
+	assume    func(ctx context.Context) (*roleKeys, error)
+}
+
+// roleKeys one set of temporary credentials of the role
+type roleKeys struct {
+	secretID  string
+	secretKey string
+	token     string
+	expiresAt time.Time
+}
+
+func newRoleCredential(source common.CredentialIface, roleARN, externalID string) *roleCredential {
+	c := &roleCredential{source: source, roleARN: roleARN, externalID: externalID}
+	c.keys.Store(&roleKeys{})
+	c.assume = c.assumeRole
+	return c
+}
+
+// assumeRoleResponse response of the STS AssumeRole API
+type assumeRoleResponse struct {
+	Response struct {
+		Credentials struct {
+			Token        string `json:"Token"`
+			TmpSecretId  string `json:"TmpSecretId"`
+			TmpSecretKey string `json:"TmpSecretKey"`
+		} `json:"Credentials"`
+		ExpiredTime int64 `json:"ExpiredTime"`
+	} `json:"Response"`
+}
+
+// GetSecretId GetSecretKey and GetToken read the current credentials without refreshing them,
+// the SDK signs with GetCredential which refreshes them and returns a matching set
+func (c *roleCredential) GetSecretId() string {
+	return c.keys.Load().secretID
+}
+
+func (c *roleCredential) GetSecretKey() string {
+	return c.keys.Load().secretKey
+}
+
+func (c *roleCredential) GetToken() string {
+	return c.keys.Load().token
+}
+
+// GetCredential return the role credentials, refreshing them if they are about to expire.
+// While the current credentials are still valid, the callers don't wait for a refresh already in progress.
+// A failed refresh keeps the current credentials, the API calls fail once they are expired
+func (c *roleCredential) GetCredential() (string, string, string) {
+	keys := c.keys.Load()
+	if time.Until(keys.expiresAt) < roleRefreshBefore {
+		if time.Now().Before(keys.expiresAt) {
+			if c.refreshMu.TryLock() {
+				keys = c.refreshLocked()
+			}
+		} else {
+			c.refreshMu.Lock()
+			keys = c.refreshLocked()
+		}
+	}
+	return keys.secretID, keys.secretKey, keys.token
+}
+
+// refreshLocked assume the role again unless another caller just did, the caller holds refreshMu
+func (c *roleCredential) refreshLocked() *roleKeys {
+	defer c.refreshMu.Unlock()
+	if keys := c.keys.Load(); time.Until(keys.expiresAt) >= roleRefreshBefore {
+		return keys
+	}
+	if err := c.refresh(); err != nil {
+		klog.Errorf("Failed to refresh the credentials of role %s: %v", c.roleARN, err)
+	}
+	return c.keys.Load()
+}
+
+// refresh assume the role again within stsTimeout
+func (c *roleCredential) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), stsTimeout)
+	defer cancel()
+	keys, err := c.assume(ctx)
+	if err != nil {
+		return err
+	}
+	c.keys.Store(keys)
+	klog.Infof("assumed role %s until %s", c.roleARN, keys.expiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// assumeRole call STS AssumeRole with the source credentials
+func (c *roleCredential) assumeRole(ctx context.Context) (*roleKeys, error) {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = stsEndpoint
+	cpf.HttpProfile.ReqMethod = "POST"
+	cpf.HttpProfile.ReqTimeout = int(stsTimeout / time.Second)
+	client := common.NewCommonClient(c.source, stsRegion, cpf)
+
+	params := map[string]interface{}{
+		"RoleArn":         c.roleARN,
+		"RoleSessionName": roleSessionName,
+		"DurationSeconds": roleSessionSeconds,
+	}
+	if c.externalID != "" {
+		params["ExternalId"] = c.externalID
+	}
+	request := tchttp.NewCommonRequest("sts", "2018-08-13", "AssumeRole")
+	request.SetContext(ctx)
+	if err := request.SetActionParameters(params); err != nil {
+		return nil, err
+	}
+	response := tchttp.NewCommonResponse()
+	if err := client.Send(request, response); err != nil {
+		return nil, err
+	}
+	var resp assumeRoleResponse
+	if err := json.Unmarshal(response.GetBody(), &resp); err != nil {
+		return nil, fmt.Errorf("decode AssumeRole response: %w", err)
+	}
+	creds := resp.Response.Credentials
+	if creds.TmpSecretId == "" || creds.TmpSecretKey == "" {
+		return nil, fmt.Errorf("AssumeRole returned no credentials")
+	}
+	return &roleKeys{
+		secretID:  creds.TmpSecretId,
+		secretKey: creds.TmpSecretKey,
+		token:     creds.Token,
+		expiresAt: time.Unix(resp.Response.ExpiredTime, 0),
+	}, nil
+}
@@ -0,0 +1,75 @@
+package tencentcloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoleCredentialRefreshesOnceWithMatchingKeys(t *testing.T) {
+	c := newRoleCredential(common.NewCredential("id", "key"), "qcs::cam::uin/1:roleName/nodes", "")
+	var calls atomic.Int32
+	c.assume = func(ctx context.Context) (*roleKeys, error) {
+		n := calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return &roleKeys{
+			secretID:  fmt.Sprintf("id-%d", n),
+			secretKey: fmt.Sprintf("key-%d", n),
+			token:     fmt.Sprintf("token-%d", n),
+			expiresAt: time.Now().Add(time.Hour),
+		}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, key, token := c.GetCredential()
+			if id != "id-1" || key != "key-1" || token != "token-1" {
+				t.Errorf("expected the credentials of the single refresh, got %s %s %s", id, key, token)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected expired credentials to be refreshed once, got %d AssumeRole calls", n)
+	}
+	if c.GetSecretId() != "id-1" || c.GetSecretKey() != "key-1" || c.GetToken() != "token-1" {
+		t.Errorf("expected the getters to return the current credentials")
+	}
+}
+
+func TestRoleCredentialValidKeysDontWaitForRefresh(t *testing.T) {
+	c := newRoleCredential(common.NewCredential("id", "key"), "qcs::cam::uin/1:roleName/nodes", "")
+	c.keys.Store(&roleKeys{secretID: "old-id", secretKey: "old-key", token: "old-token", expiresAt: time.Now().Add(time.Minute)})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	c.assume = func(ctx context.Context) (*roleKeys, error) {
+		close(started)
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil, fmt.Errorf("sts unavailable")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.GetCredential()
+	}()
+	<-started
+	if id, key, token := c.GetCredential(); id != "old-id" || key != "old-key" || token != "old-token" {
+		t.Errorf("expected the still valid credentials during the refresh, got %s %s %s", id, key, token)
+	}
+	close(release)
+	<-done
+	if id := c.GetSecretId(); id != "old-id" {
+		t.Errorf("expected a failed refresh to keep the current credentials, got %s", id)
+	}
+}
@@ -2,19 +2,47 @@ package tencentcloud
 
 import (
 	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/metrics"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tcerr "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
 	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 
+	"net"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
+// maxBatchSize max instance ids per DescribeInstances call
+const maxBatchSize = 100
+
+// defaultEndpoint public endpoint of the CVM API
+const defaultEndpoint = "cvm.tencentcloudapi.com"
+
+// zoneRegionPattern region of a zone or region name, e.g. ap-singapore of ap-singapore-1, ap-shanghai-fsi of ap-shanghai-fsi-2
+var zoneRegionPattern = regexp.MustCompile(`^([a-z]+(?:-[a-z]+)+)(?:-\d+)?$`)
+
+// numericZonePattern numeric zone id used by TKE in providerIDs, e.g. 800002, it doesn't tell the region
+var numericZonePattern = regexp.MustCompile(`^\d+$`)
+
 // Tencent tencent cloud provider
 type Tencent struct {
-	client *cvm.Client
+	credential     common.CredentialIface
+	profile        *profile.ClientProfile
+	regions        []string // allowed regions, in the order of the options
+	allowedRegions map[string]bool
+
+	mu      sync.Mutex
+	clients map[string]*cvm.Client // keyed by region
 }
 
 // parseInstanceFromProviderID parse instance id from provider id
@@ -30,46 +58,301 @@ func parseInstanceFromProviderID(node *v1.Node) (string, string, error) {
 
 // InitTencentCloudProvider init tencent cloud provider
 func InitTencentCloudProvider() (*Tencent, error) {
-	credential := common.NewCredential(config.Options.AccessKeyID, config.Options.SecretKeyID)
+	credential, err := newCredential(config.Options.Tencent)
+	if err != nil {
+		return nil, err
+	}
+	t := newTencent(config.Options.Tencent, credential)
+	// 初始化客户端
+	client, err := t.client(config.Options.Tencent.Region)
+	if err != nil {
+		return nil, fmt.Errorf("create tencent client of region %s: %w", config.Options.Tencent.Region, err)
+	}
+	if err := checkCredential(client, config.Options.CloudCallTimeout); err != nil {
+		return nil, fmt.Errorf("invalid tencent credentials: %w", err)
+	}
+	return t, nil
+}
+
+// checkCredential list one instance with the permission the node checks need,
+// so a misconfigured credential fails at startup instead of on the first node check
+func checkCredential(client *cvm.Client, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	request := cvm.NewDescribeInstancesRequest()
+	request.Limit = common.Int64Ptr(1)
+	if _, err := client.DescribeInstancesWithContext(ctx, request); err != nil {
+		return fmt.Errorf("describe instances: %w", err)
+	}
+	return nil
+}
+
+// newTencent create the provider with its credential, the clients are created on first use
+func newTencent(o option.ProviderOptions, credential common.CredentialIface) *Tencent {
 	// 设置客户端配置
 	cpf := profile.NewClientProfile()
-	cpf.HttpProfile.Endpoint = "cvm.tencentcloudapi.com"
-
-	// 初始化客户端
-	client, err := cvm.NewClient(credential, config.Options.Region, cpf)
+	cpf.HttpProfile.Endpoint = o.Endpoint
+	if cpf.HttpProfile.Endpoint == "" {
+		cpf.HttpProfile.Endpoint = defaultEndpoint
+	}
+	t := &Tencent{
+		credential:     credential,
+		profile:        cpf,
+		regions:        o.Regions(),
+		allowedRegions: map[string]bool{},
+		clients:        map[string]*cvm.Client{},
+	}
+	for _, region := range t.regions {
+		t.allowedRegions[region] = true
+	}
+	return t
+}
 
+// client return the CVM client of a region, created on first use and cached
+func (t *Tencent) client(region string) (*cvm.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if client, ok := t.clients[region]; ok {
+		return client, nil
+	}
+	client, err := cvm.NewClient(t.credential, region, t.profile)
 	if err != nil {
-		panic(fmt.Sprintf("创建客户端失败: %v", err))
+		return nil, err
 	}
+	t.clients[region] = client
+	return client, nil
+}
 
-	return &Tencent{client}, nil
+// locate return the region and the instance id of the node, the region is derived from the zone of the providerID
+// and must be allowed: checking an instance in the wrong region would report it missing and delete its node.
+// Numeric zone ids don't tell the region, the nodes using them are only checked when a single region is allowed.
+// A region that can't be used is a permanent error, the node is skipped until the configuration changes
+func (t *Tencent) locate(node *v1.Node) (string, string, error) {
+	zone, instanceID, err := parseInstanceFromProviderID(node)
+	if err != nil {
+		return "", "", err
+	}
+	var region string
+	if numericZonePattern.MatchString(zone) {
+		if len(t.regions) != 1 {
+			return "", "", types.NewCloudError("tencent", types.ErrorPermanent, fmt.Errorf("numeric zone %q of providerID %s doesn't tell the region, it needs a single allowed region, allowed regions: %s",
+				zone, node.Spec.ProviderID, strings.Join(t.regions, ",")))
+		}
+		region = t.regions[0]
+	} else {
+		match := zoneRegionPattern.FindStringSubmatch(zone)
+		if match == nil {
+			return "", "", types.NewCloudError("tencent", types.ErrorPermanent,
+				fmt.Errorf("can't derive the region of zone %q of providerID %s", zone, node.Spec.ProviderID))
+		}
+		region = match[1]
+	}
+	if !t.allowedRegions[region] {
+		return "", "", types.NewCloudError("tencent", types.ErrorPermanent, fmt.Errorf("region %s of providerID %s is not allowed, allowed regions: %s",
+			region, node.Spec.ProviderID, strings.Join(t.regions, ",")))
+	}
+	return region, instanceID, nil
 }
 
-// CheckNodeInstanceExists check node instance exists
-func (t *Tencent) CheckNodeInstanceExists(node *v1.Node) (bool, error) {
+// GetInstanceStatus get the status of the CVM instance backing the node
+func (t *Tencent) GetInstanceStatus(ctx context.Context, node *v1.Node) (*types.InstanceStatus, error) {
 	providerID := node.Spec.ProviderID
-	_, instanceID, err := parseInstanceFromProviderID(node)
-	region := config.Options.Region
+	region, instanceID, err := t.locate(node)
 	if err != nil {
-		klog.Errorf("Failed to parse instance ID from provider ID %s: %v", providerID, err)
-		return false, err
+		klog.Errorf("Failed to locate instance of provider ID %s: %v", providerID, err)
+		return nil, err
 	}
 	klog.Infof("region: %s, instanceID: %s", region, instanceID)
+	client, err := t.client(region)
+	if err != nil {
+		return nil, err
+	}
 	// 创建请求并设置实例ID
 	request := cvm.NewDescribeInstancesRequest()
 	request.InstanceIds = common.StringPtrs([]string{instanceID})
-	resp, err := t.client.DescribeInstances(request)
+	start := time.Now()
+	resp, err := client.DescribeInstancesWithContext(ctx, request)
+	metrics.ObserveProviderCall("tencent", "DescribeInstances", start, err)
 	if err != nil {
+		err = classifyError(err)
+		if types.IsNotFound(err) {
+			klog.Infof("Instance %s not found, has been released.", instanceID)
+			return types.NewNotFoundStatus(providerID), nil
+		}
 		klog.Errorf("Failed to describe  %s: %v", instanceID, err)
-		return true, err
+		return nil, err
 	}
 	if len(resp.Response.InstanceSet) == 0 {
 		klog.Infof("Instance %s not found, has been released.\n", instanceID)
-		return false, nil
+		return types.NewNotFoundStatus(providerID), nil
 	}
 	instance := resp.Response.InstanceSet[0]
+	if instance.InstanceState == nil {
+		klog.Warningf("Instance %s has no state", instanceID)
+		return &types.InstanceStatus{State: types.InstanceUnknown, ProviderID: providerID}, nil
+	}
 	state := *instance.InstanceState
 
 	klog.Infof("Instance %s state: %s", instanceID, state)
-	return state != "TERMINATING", nil
+	return &types.InstanceStatus{
+		State:         instanceState(state),
+		ProviderState: state,
+		LaunchTime:    parseCreatedTime(instance.CreatedTime),
+		ProviderID:    providerID,
+	}, nil
+}
+
+// GetInstanceStatuses look up the instances of each region in batches of maxBatchSize,
+// the instances absent from the response are not found
+func (t *Tencent) GetInstanceStatuses(ctx context.Context, nodes []*v1.Node) types.InstanceResults {
+	results := types.InstanceResults{}
+	providerIDs := map[string]string{}   // instance id -> providerID
+	instanceIDs := map[string][]string{} // region -> instance ids
+	for _, node := range nodes {
+		region, instanceID, err := t.locate(node)
+		if err != nil {
+			results[node.Spec.ProviderID] = types.InstanceResult{Err: err}
+			continue
+		}
+		if _, ok := providerIDs[instanceID]; !ok {
+			instanceIDs[region] = append(instanceIDs[region], instanceID)
+		}
+		providerIDs[instanceID] = node.Spec.ProviderID
+	}
+	for region, ids := range instanceIDs {
+		client, err := t.client(region)
+		if err != nil {
+			for _, instanceID := range ids {
+				results[providerIDs[instanceID]] = types.InstanceResult{Err: err}
+			}
+			continue
+		}
+		for len(ids) > 0 {
+			chunk := ids[:min(len(ids), maxBatchSize)]
+			ids = ids[len(chunk):]
+			describeBatch(ctx, client, chunk, providerIDs, results)
+		}
+	}
+	return results
+}
+
+// describeBatch describe one batch of instances and fill their results, a batch failing because one of its instances
+// doesn't exist is split in halves until the missing instances are isolated. The instance-id filter, which skips the missing
+// instances, takes 5 values at most
+func describeBatch(ctx context.Context, client *cvm.Client, instanceIDs []string, providerIDs map[string]string, results types.InstanceResults) {
+	request := cvm.NewDescribeInstancesRequest()
+	request.InstanceIds = common.StringPtrs(instanceIDs)
+	request.Limit = common.Int64Ptr(int64(len(instanceIDs)))
+	start := time.Now()
+	resp, err := client.DescribeInstancesWithContext(ctx, request)
+	metrics.ObserveProviderCall("tencent", "DescribeInstances", start, err)
+	if err = classifyError(err); types.IsNotFound(err) {
+		if len(instanceIDs) == 1 {
+			klog.Infof("Instance %s not found, has been released.", instanceIDs[0])
+			results[providerIDs[instanceIDs[0]]] = types.InstanceResult{Status: types.NewNotFoundStatus(providerIDs[instanceIDs[0]])}
+			return
+		}
+		half := len(instanceIDs) / 2
+		describeBatch(ctx, client, instanceIDs[:half], providerIDs, results)
+		describeBatch(ctx, client, instanceIDs[half:], providerIDs, results)
+		return
+	}
+	if err != nil {
+		klog.Errorf("Failed to describe %d instances: %v", len(instanceIDs), err)
+		for _, instanceID := range instanceIDs {
+			results[providerIDs[instanceID]] = types.InstanceResult{Err: err}
+		}
+		return
+	}
+	found := map[string]bool{}
+	for _, instance := range resp.Response.InstanceSet {
+		if instance.InstanceId == nil {
+			continue
+		}
+		providerID, ok := providerIDs[*instance.InstanceId]
+		if !ok {
+			continue
+		}
+		found[*instance.InstanceId] = true
+		if instance.InstanceState == nil {
+			// the instance exists, a missing state must not be taken for a released instance
+			klog.Warningf("Instance %s has no state", *instance.InstanceId)
+			results[providerID] = types.InstanceResult{Status: &types.InstanceStatus{State: types.InstanceUnknown, ProviderID: providerID}}
+			continue
+		}
+		state := *instance.InstanceState
+		results[providerID] = types.InstanceResult{Status: &types.InstanceStatus{
+			State:         instanceState(state),
+			ProviderState: state,
+			LaunchTime:    parseCreatedTime(instance.CreatedTime),
+			ProviderID:    providerID,
+		}}
+	}
+	for _, instanceID := range instanceIDs {
+		if !found[instanceID] {
+			klog.Infof("Instance %s not found, has been released.", instanceID)
+			results[providerIDs[instanceID]] = types.InstanceResult{Status: types.NewNotFoundStatus(providerIDs[instanceID])}
+		}
+	}
+}
+
+// instanceState map CVM instance states to instance states, SHUTDOWN instances are isolated and wait to be released,
+// LAUNCH_FAILED instances never started: both are gone for the node
+func instanceState(state string) types.InstanceState {
+	switch state {
+	case "PENDING", "STARTING", "RUNNING", "REBOOTING":
+		return types.InstanceRunning
+	case "STOPPING", "STOPPED":
+		return types.InstanceStopped
+	case "SHUTDOWN", "TERMINATING":
+		return types.InstanceTerminating
+	case "LAUNCH_FAILED":
+		return types.InstanceTerminated
+	default:
+		return types.InstanceUnknown
+	}
+}
+
+// classifyError map a CVM API error into the provider error taxonomy, the unrecognized errors are returned as is
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var sdkErr *tcerr.TencentCloudSDKError
+	if !errors.As(err, &sdkErr) {
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+			return types.NewCloudError("tencent", types.ErrorTransient, err)
+		}
+		return err
+	}
+	switch code := sdkErr.GetCode(); {
+	case code == "InvalidInstanceId.NotFound":
+		return types.NewCloudError("tencent", types.ErrorNotFound, err)
+	case strings.HasPrefix(code, "RequestLimitExceeded"):
+		return types.NewThrottledError("tencent", 0, err)
+	case strings.HasPrefix(code, "AuthFailure"), strings.HasPrefix(code, "UnauthorizedOperation"),
+		code == "ClientError.CredentialError":
+		return types.NewCloudError("tencent", types.ErrorAuthFailure, err)
+	case strings.HasPrefix(code, "InternalError"), code == "ClientError.NetworkError",
+		code == "ClientError.CircuitBreakerError", code == "ClientError.HttpStatusCodeError":
+		return types.NewCloudError("tencent", types.ErrorTransient, err)
+	case strings.HasPrefix(code, "InvalidParameter"), strings.HasPrefix(code, "MissingParameter"),
+		strings.HasPrefix(code, "UnknownParameter"), strings.HasPrefix(code, "UnsupportedOperation"),
+		code == "InvalidInstanceId.Malformed", code == "InvalidAction":
+		return types.NewCloudError("tencent", types.ErrorPermanent, err)
+	}
+	return err
+}
+
+// parseCreatedTime parse the ISO8601 creation time of a CVM instance
+func parseCreatedTime(createdTime *string) *time.Time {
+	if createdTime == nil {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, *createdTime)
+	if err != nil {
+		return nil
+	}
+	return &t
 }
@@ -1,10 +1,23 @@
 package tencentcloud
 
 import (
+	"cloud-node-lifecycle-controller/pkg/config"
+	"cloud-node-lifecycle-controller/pkg/option"
+	"cloud-node-lifecycle-controller/pkg/provider/types"
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/google/uuid"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tcerr "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestAwsCheckNode(t *testing.T) {
@@ -16,12 +29,190 @@ func TestAwsCheckNode(t *testing.T) {
 			ProviderID: "qcloud:///ap-singapore/ins-qoo7r6aw",
 		},
 	}
+	config.Options = &option.Options{Tencent: option.ProviderOptions{Region: "ap-singapore"}}
 	api, err := InitTencentCloudProvider()
 	if err != nil {
 		return
 	}
-	_, err = api.CheckNodeInstanceExists(node)
+	_, err = api.GetInstanceStatus(context.Background(), node)
 	if err != nil {
 		return
 	}
 }
+
+func TestLocateRegionFromProviderID(t *testing.T) {
+	config.Options = &option.Options{Tencent: option.ProviderOptions{Region: "ap-singapore", AllowedRegions: []string{"ap-singapore", "ap-shanghai-fsi"}}}
+	api := newTencent(config.Options.Tencent, common.NewCredential("id", "key"))
+	cases := []struct {
+		providerID string
+		region     string
+		wantErr    bool
+	}{
+		{providerID: "qcloud:///ap-singapore/ins-1", region: "ap-singapore"},
+		{providerID: "qcloud:///ap-shanghai-fsi-2/ins-2", region: "ap-shanghai-fsi"},
+		{providerID: "qcloud:///900001/ins-3", wantErr: true},
+		{providerID: "qcloud:///ap-guangzhou-3/ins-4", wantErr: true},
+	}
+	for _, tc := range cases {
+		region, _, err := api.locate(&v1.Node{Spec: v1.NodeSpec{ProviderID: tc.providerID}})
+		if tc.wantErr {
+			if types.KindOf(err) != types.ErrorPermanent {
+				t.Errorf("%s: expected a permanent error, got region %s, %v", tc.providerID, region, err)
+			}
+			continue
+		}
+		if err != nil || region != tc.region {
+			t.Errorf("%s: expected region %s, got %s, %v", tc.providerID, tc.region, region, err)
+		}
+	}
+
+	config.Options = &option.Options{Tencent: option.ProviderOptions{Region: "ap-singapore"}}
+	api = newTencent(config.Options.Tencent, common.NewCredential("id", "key"))
+	if region, _, err := api.locate(&v1.Node{Spec: v1.NodeSpec{ProviderID: "qcloud:///900001/ins-3"}}); err != nil || region != "ap-singapore" {
+		t.Errorf("expected a numeric zone to be checked in the only allowed region, got %s, %v", region, err)
+	}
+}
+
+func TestInstanceState(t *testing.T) {
+	cases := map[string]types.InstanceState{
+		"PENDING":       types.InstanceRunning,
+		"STARTING":      types.InstanceRunning,
+		"RUNNING":       types.InstanceRunning,
+		"REBOOTING":     types.InstanceRunning,
+		"STOPPING":      types.InstanceStopped,
+		"STOPPED":       types.InstanceStopped,
+		"SHUTDOWN":      types.InstanceTerminating,
+		"TERMINATING":   types.InstanceTerminating,
+		"LAUNCH_FAILED": types.InstanceTerminated,
+		"ISOLATING":     types.InstanceUnknown,
+	}
+	for state, expected := range cases {
+		if got := instanceState(state); got != expected {
+			t.Errorf("state %s: expected %s, got %s", state, expected, got)
+		}
+	}
+}
+
+func TestNewTencentEndpoint(t *testing.T) {
+	if got := newTencent(option.ProviderOptions{}, nil).profile.HttpProfile.Endpoint; got != defaultEndpoint {
+		t.Errorf("expected default endpoint %s, got %s", defaultEndpoint, got)
+	}
+	o := option.ProviderOptions{Endpoint: "cvm.internal.tencentcloudapi.com"}
+	if got := newTencent(o, nil).profile.HttpProfile.Endpoint; got != o.Endpoint {
+		t.Errorf("expected endpoint %s, got %s", o.Endpoint, got)
+	}
+}
+
+func TestCheckCredential(t *testing.T) {
+	var rejected atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if rejected.Load() {
+			w.Write([]byte(`{"Response":{"Error":{"Code":"AuthFailure.SecretIdNotFound","Message":"secret id not found"},"RequestId":"1"}}`))
+			return
+		}
+		w.Write([]byte(`{"Response":{"TotalCount":0,"InstanceSet":[],"RequestId":"1"}}`))
+	}))
+	defer server.Close()
+	api := newTencent(option.ProviderOptions{Endpoint: strings.TrimPrefix(server.URL, "http://")}, common.NewCredential("id", "key"))
+	api.profile.HttpProfile.Scheme = "HTTP"
+	client, err := api.client("ap-singapore")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := checkCredential(client, 5*time.Second); err != nil {
+		t.Errorf("expected valid credentials, got %v", err)
+	}
+	rejected.Store(true)
+	if err := checkCredential(client, 5*time.Second); err == nil {
+		t.Errorf("expected rejected credentials to fail the check")
+	}
+}
+
+func TestDescribeBatchIsolatesMissingInstances(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var request struct{ InstanceIds []string }
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("unexpected request body: %v", err)
+		}
+		var instances []string
+		for _, id := range request.InstanceIds {
+			if id == "ins-gone" {
+				w.Write([]byte(`{"Response":{"Error":{"Code":"InvalidInstanceId.NotFound","Message":"not found"},"RequestId":"1"}}`))
+				return
+			}
+			instances = append(instances, fmt.Sprintf(`{"InstanceId":%q,"InstanceState":"RUNNING"}`, id))
+		}
+		fmt.Fprintf(w, `{"Response":{"TotalCount":%d,"InstanceSet":[%s],"RequestId":"1"}}`, len(instances), strings.Join(instances, ","))
+	}))
+	defer server.Close()
+	api := newTencent(option.ProviderOptions{Endpoint: strings.TrimPrefix(server.URL, "http://")}, common.NewCredential("id", "key"))
+	api.profile.HttpProfile.Scheme = "HTTP"
+	client, err := api.client("ap-singapore")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ids := []string{"ins-1", "ins-2", "ins-3", "ins-gone", "ins-5", "ins-6", "ins-7", "ins-8"}
+	providerIDs := map[string]string{}
+	for _, id := range ids {
+		providerIDs[id] = "qcloud:///800001/" + id
+	}
+	results := types.InstanceResults{}
+	describeBatch(context.Background(), client, ids, providerIDs, results)
+	for _, id := range ids {
+		result := results[providerIDs[id]]
+		want := types.InstanceRunning
+		if id == "ins-gone" {
+			want = types.InstanceNotFound
+		}
+		if result.Err != nil || result.Status == nil || result.Status.State != want {
+			t.Errorf("expected %s to be %s, got %+v", id, want, result)
+		}
+	}
+	if n := calls.Load(); n >= int32(len(ids)+1) {
+		t.Errorf("expected the batch to be split instead of described one instance at a time, got %d calls", n)
+	}
+}
+
+func TestInstanceWithoutStateIsUnknown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Response":{"TotalCount":1,"InstanceSet":[{"InstanceId":"ins-1"}],"RequestId":"1"}}`))
+	}))
+	defer server.Close()
+	config.Options = &option.Options{Tencent: option.ProviderOptions{Region: "ap-singapore"}}
+	api := newTencent(option.ProviderOptions{Region: "ap-singapore", Endpoint: strings.TrimPrefix(server.URL, "http://")}, common.NewCredential("id", "key"))
+	api.profile.HttpProfile.Scheme = "HTTP"
+	node := &v1.Node{Spec: v1.NodeSpec{ProviderID: "qcloud:///ap-singapore-1/ins-1"}}
+
+	status, err := api.GetInstanceStatus(context.Background(), node)
+	if err != nil || status.State != types.InstanceUnknown {
+		t.Errorf("expected an instance without state to be unknown, got %+v, %v", status, err)
+	}
+	results := api.GetInstanceStatuses(context.Background(), []*v1.Node{node, {Spec: v1.NodeSpec{ProviderID: "qcloud:///ap-singapore-1/ins-2"}}})
+	if result := results[node.Spec.ProviderID]; result.Err != nil || result.Status.State != types.InstanceUnknown {
+		t.Errorf("expected a batched instance without state to be unknown, got %+v", result)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := map[string]types.ErrorKind{
+		"InvalidInstanceId.NotFound":                        types.ErrorNotFound,
+		"RequestLimitExceeded":                              types.ErrorThrottled,
+		"RequestLimitExceeded.GlobalRegionUinLimitExceeded": types.ErrorThrottled,
+		"AuthFailure.SecretIdNotFound":                      types.ErrorAuthFailure,
+		"UnauthorizedOperation":                             types.ErrorAuthFailure,
+		"InternalError":                                     types.ErrorTransient,
+		"ClientError.NetworkError":                          types.ErrorTransient,
+		"InvalidInstanceId.Malformed":                       types.ErrorPermanent,
+		"ResourceInsufficient":                              types.ErrorUnknown,
+	}
+	for code, kind := range cases {
+		if got := types.KindOf(classifyError(tcerr.NewTencentCloudSDKError(code, "message", "request"))); got != kind {
+			t.Errorf("%s: expected %s, got %s", code, kind, got)
+		}
+	}
+}
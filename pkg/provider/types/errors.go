@@ -0,0 +1,103 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedProviderID the providerID scheme of the node doesn't match any enabled cloud provider
+var ErrUnsupportedProviderID = errors.New("unsupported providerID")
+
+// IsUnsupportedProviderID whether the error reports a node no enabled cloud provider can handle
+func IsUnsupportedProviderID(err error) bool {
+	return errors.Is(err, ErrUnsupportedProviderID)
+}
+
+// ErrorKind class of a cloud API error, it tells the controller how to react to the error
+type ErrorKind string
+
+// cloud API error kinds, the errors a provider doesn't classify are unknown and never mean the instance is gone
+const (
+	ErrorNotFound    ErrorKind = "NotFound"    // the instance doesn't exist
+	ErrorThrottled   ErrorKind = "Throttled"   // the API rate limit is exceeded, retry after a while
+	ErrorAuthFailure ErrorKind = "AuthFailure" // the credentials are invalid, expired or not allowed
+	ErrorTransient   ErrorKind = "Transient"   // network or server error, retrying may succeed
+	ErrorPermanent   ErrorKind = "Permanent"   // invalid request, retrying won't help
+	ErrorUnknown     ErrorKind = "Unknown"
+)
+
+// CloudError error of a cloud API call classified by its provider
+type CloudError struct {
+	Provider   string
+	Kind       ErrorKind
+	RetryAfter time.Duration // delay requested by a throttled API, 0 if it didn't tell
+	Err        error
+}
+
+func (e *CloudError) Error() string {
+	return fmt.Sprintf("%s %s error: %v", e.Provider, e.Kind, e.Err)
+}
+
+func (e *CloudError) Unwrap() error {
+	return e.Err
+}
+
+// NewCloudError classify the error of a cloud API call, nil stays nil
+func NewCloudError(provider string, kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CloudError{Provider: provider, Kind: kind, Err: err}
+}
+
+// NewThrottledError a throttling error of a cloud API call, retryAfter is 0 if the API didn't tell when to retry
+func NewThrottledError(provider string, retryAfter time.Duration, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CloudError{Provider: provider, Kind: ErrorThrottled, RetryAfter: retryAfter, Err: err}
+}
+
+// KindOf the kind of a cloud API error, ErrorUnknown if the provider didn't classify it
+func KindOf(err error) ErrorKind {
+	var cloudErr *CloudError
+	if errors.As(err, &cloudErr) {
+		return cloudErr.Kind
+	}
+	return ErrorUnknown
+}
+
+// IsNotFound whether the error reports the instance doesn't exist
+func IsNotFound(err error) bool {
+	return KindOf(err) == ErrorNotFound
+}
+
+// RetryAfter the delay requested by a throttled API, 0 if the error is not a throttling error or the API didn't tell
+func RetryAfter(err error) time.Duration {
+	var cloudErr *CloudError
+	if errors.As(err, &cloudErr) && cloudErr.Kind == ErrorThrottled {
+		return cloudErr.RetryAfter
+	}
+	return 0
+}
+
+// ParseRetryAfter parse a Retry-After header, either a number of seconds or an HTTP date, 0 if it is empty or invalid
+func ParseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestKindOf(t *testing.T) {
+	err := fmt.Errorf("describe instance: %w", NewThrottledError("aws", 3*time.Second, errors.New("RequestLimitExceeded")))
+	if KindOf(err) != ErrorThrottled || RetryAfter(err) != 3*time.Second {
+		t.Errorf("expected a wrapped throttling error retried after 3s, got %s after %s", KindOf(err), RetryAfter(err))
+	}
+	if KindOf(errors.New("boom")) != ErrorUnknown || IsNotFound(errors.New("not found")) {
+		t.Errorf("expected an unclassified error to be unknown")
+	}
+	if NewCloudError("aws", ErrorNotFound, nil) != nil {
+		t.Errorf("expected a nil error to stay nil")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := ParseRetryAfter("30"); got != 30*time.Second {
+		t.Errorf("expected 30s, got %s", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := ParseRetryAfter(date); got <= 0 || got > time.Minute {
+		t.Errorf("expected about 1m, got %s", got)
+	}
+	for _, value := range []string{"", "soon", "-5"} {
+		if got := ParseRetryAfter(value); got != 0 {
+			t.Errorf("%q: expected 0, got %s", value, got)
+		}
+	}
+}
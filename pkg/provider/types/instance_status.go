@@ -0,0 +1,41 @@
+package types
+
+import "time"
+
+// InstanceState normalized state of a cloud instance
+type InstanceState string
+
+// instance states reported by the cloud providers
+const (
+	InstanceRunning     InstanceState = "Running"
+	InstanceStopped     InstanceState = "Stopped"
+	InstanceTerminating InstanceState = "Terminating"
+	InstanceTerminated  InstanceState = "Terminated"
+	InstanceNotFound    InstanceState = "NotFound"
+	InstanceUnknown     InstanceState = "Unknown"
+)
+
+// InstanceStatus status of the instance backing a node
+type InstanceStatus struct {
+	State         InstanceState `json:"state"`
+	ProviderState string        `json:"providerState,omitempty"` // raw state as reported by the cloud API
+	LaunchTime    *time.Time    `json:"launchTime,omitempty"`
+	ProviderID    string        `json:"providerID"`
+}
+
+// NewNotFoundStatus status of an instance the cloud provider doesn't know about
+func NewNotFoundStatus(providerID string) *InstanceStatus {
+	return &InstanceStatus{
+		State:      InstanceNotFound,
+		ProviderID: providerID,
+	}
+}
+
+// InstanceResult outcome of the lookup of one instance in a batch, either a status or an error
+type InstanceResult struct {
+	Status *InstanceStatus
+	Err    error
+}
+
+// InstanceResults results of a batch lookup keyed by providerID
+type InstanceResults map[string]InstanceResult
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"cloud-node-lifecycle-controller/pkg/controller"
+	"cloud-node-lifecycle-controller/pkg/entity"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"k8s.io/klog/v2"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminTokenFile file holding the bearer token of the admin endpoints, read on every request so a rotated token
+// is used without a restart
+var adminTokenFile string
+
+// registerAdmin register the admin endpoints, they need the bearer token of --admin-token-file
+func registerAdmin(tokenFile string) {
+	adminTokenFile = tokenFile
+	http.HandleFunc("/admin/state", requireAdmin(AdminState))
+	http.HandleFunc("/admin/pause", requireAdmin(PauseDeletions))
+	http.HandleFunc("/admin/resume", requireAdmin(ResumeDeletions))
+	http.HandleFunc("/admin/reconcile", requireAdmin(ReconcileNode))
+	http.HandleFunc("/admin/clear-backoff", requireAdmin(ClearNodeBackoff))
+}
+
+// requireAdmin serve the request only if it carries the admin bearer token, the token is compared in constant time
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminTokenFile == "" {
+			writeError(w, http.StatusForbidden, "admin endpoints are disabled, set --admin-token-file")
+			return
+		}
+		data, err := os.ReadFile(adminTokenFile)
+		token := strings.TrimSpace(string(data))
+		if err != nil || token == "" {
+			klog.Errorf("read admin token file %s error: %v", adminTokenFile, err)
+			writeError(w, http.StatusInternalServerError, "admin token not available")
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AdminState report the paused deletions and the nodes whose instance is missing or which are being drained
+func AdminState(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	state, err := controller.GetAdminState()
+	writeResult(w, state, err)
+}
+
+// PauseDeletions pause the deletions of ?provider=, or of every provider, with an optional ?reason=
+func PauseDeletions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	state, err := controller.PauseDeletions(r.Context(), r.URL.Query().Get("provider"), r.URL.Query().Get("reason"))
+	writeResult(w, state, err)
+}
+
+// ResumeDeletions resume the deletions of ?provider=, or lift every pause
+func ResumeDeletions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	state, err := controller.ResumeDeletions(r.Context(), r.URL.Query().Get("provider"))
+	writeResult(w, state, err)
+}
+
+// ReconcileNode process ?node= right away, or every node
+func ReconcileNode(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	writeResult(w, nil, controller.ReconcileNode(r.URL.Query().Get("node")))
+}
+
+// ClearNodeBackoff reset the workqueue backoff of ?node= and process it right away
+func ClearNodeBackoff(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	node := r.URL.Query().Get("node")
+	if node == "" {
+		writeError(w, http.StatusBadRequest, "node is required")
+		return
+	}
+	writeResult(w, nil, controller.ClearNodeBackoff(node))
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// writeResult write the data of a successful admin operation, or its error with the matching status
+func writeResult(w http.ResponseWriter, data interface{}, err error) {
+	switch {
+	case err == nil:
+		var res entity.HTTPResponse
+		res.SuccessWithData(data)
+		writeJSON(w, http.StatusOK, res)
+	case errors.Is(err, controller.ErrNotLeader):
+		// the operations act on the controller of the leader, the caller retries on the lease holder
+		var res entity.HTTPResponse
+		res.FailWithData(http.StatusConflict, err.Error(), controller.GetLeaderStatus())
+		writeJSON(w, http.StatusConflict, res)
+	case errors.Is(err, controller.ErrNodeNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, controller.ErrProviderNotEnabled):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	var res entity.HTTPResponse
+	res.FailWithData(int32(code), message, nil)
+	writeJSON(w, code, res)
+}
+
+func writeJSON(w http.ResponseWriter, code int, res entity.HTTPResponse) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(code)
+	resp, _ := json.Marshal(res)
+	w.Write(resp)
+}
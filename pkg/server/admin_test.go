@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	serve := func(handler http.HandlerFunc, authorization string) int {
+		r := httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	adminTokenFile = ""
+	if code := serve(requireAdmin(ok), "Bearer s3cr3t"); code != http.StatusForbidden {
+		t.Errorf("expected the admin endpoints to be disabled without token file, got %d", code)
+	}
+	if code := serve(requireAdmin(ResetCircuitBreaker), ""); code != http.StatusForbidden {
+		t.Errorf("expected the circuit breaker reset to be disabled without token file, got %d", code)
+	}
+
+	adminTokenFile = filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(adminTokenFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatalf("write token file: %v", err)
+	}
+	defer func() { adminTokenFile = "" }()
+	cases := []struct {
+		authorization string
+		code          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"s3cr3t", http.StatusUnauthorized},
+		{"Bearer s3cr3t", http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		if code := serve(requireAdmin(ok), tc.authorization); code != tc.code {
+			t.Errorf("authorization %q: expected %d, got %d", tc.authorization, tc.code, code)
+		}
+	}
+}
+
+func TestResetCircuitBreakerOnlyOnLeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	ResetCircuitBreaker(w, httptest.NewRequest(http.MethodPost, "/circuit-breaker/reset", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected a reset on a process that is not the leader to be refused, got %d", w.Code)
+	}
+}
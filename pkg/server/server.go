@@ -1,14 +1,23 @@
 package server
 
 import (
+	"cloud-node-lifecycle-controller/pkg/controller"
 	"cloud-node-lifecycle-controller/pkg/entity"
+	"cloud-node-lifecycle-controller/pkg/metrics"
 	"encoding/json"
 	"net/http"
 )
 
-// NewAPIServer create new http server
-func NewAPIServer(port string) {
+// NewAPIServer create new http server, the admin endpoints need the bearer token of adminTokenFile
+func NewAPIServer(port, adminTokenFile string) {
+	registerAdmin(adminTokenFile)
 	http.HandleFunc("/healthz", Healthz)
+	http.HandleFunc("/livez", Livez)
+	http.HandleFunc("/readyz", Readyz)
+	http.HandleFunc("/leader", Leader)
+	http.HandleFunc("/dry-run-report", DryRunReport)
+	http.HandleFunc("/circuit-breaker/reset", requireAdmin(ResetCircuitBreaker))
+	http.Handle("/metrics", metrics.Handler())
 	http.ListenAndServe(":"+port, nil)
 }
 
@@ -18,8 +27,69 @@ func Healthz(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 	switch r.Method {
 	case "GET":
-		res.Succ()
+		res.SuccessWithData(map[string]interface{}{
+			"circuitBreaker": controller.GetCircuitBreakerStatus(),
+			"cloudProviders": controller.GetCloudProviderStatus(),
+			"resync":         controller.GetResyncStatus(),
+		})
 	}
 	resp, _ := json.Marshal(res)
 	w.Write(resp)
 }
+
+// Livez liveness probe, fails when the workers are gone or the workqueue is wedged
+func Livez(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, r, controller.Liveness())
+}
+
+// Readyz readiness probe, fails until the clients are configured and the informer is synced on the leader
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, r, controller.Readiness())
+}
+
+// writeHealthReport write the checks of a probe, with status 503 if one of them failed
+func writeHealthReport(w http.ResponseWriter, r *http.Request, report controller.HealthReport) {
+	var res entity.HTTPResponse
+	w.Header().Set("content-type", "application/json")
+	switch r.Method {
+	case "GET":
+		if report.Healthy {
+			res.SuccessWithData(report)
+		} else {
+			res.FailWithData(http.StatusServiceUnavailable, "unhealthy", report)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
+	resp, _ := json.Marshal(res)
+	w.Write(resp)
+}
+
+// Leader report the holder of the leader lease
+func Leader(w http.ResponseWriter, r *http.Request) {
+	var res entity.HTTPResponse
+	w.Header().Set("content-type", "application/json")
+	switch r.Method {
+	case "GET":
+		res.SuccessWithData(controller.GetLeaderStatus())
+	}
+	resp, _ := json.Marshal(res)
+	w.Write(resp)
+}
+
+// DryRunReport list the nodes that would have been deleted in dry-run mode, only the leader holds the report
+func DryRunReport(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	report, err := controller.GetDryRunReport()
+	writeResult(w, report, err)
+}
+
+// ResetCircuitBreaker resume node deletions after the mass-deletion circuit breaker tripped
+func ResetCircuitBreaker(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	status, err := controller.ResetCircuitBreaker(r.Context())
+	writeResult(w, status, err)
+}